@@ -0,0 +1,160 @@
+package view
+
+import (
+	"container/list"
+	"sync"
+)
+
+// CacheStats is the statistics of the template cache of a Container.
+type CacheStats struct {
+	// The number of displays which found their templates in the cache.
+	Hits      uint64
+
+	// The number of displays which had to build their templates.
+	Misses    uint64
+
+	// The number of templates removed for the size limits.
+	Evictions uint64
+
+	// The number of cached templates.
+	Templates int
+
+	// The size of the view sources of the cached templates.
+	Bytes     int
+}
+
+// lru is the template cache which removes the least recently used templates
+// when it exceeds the limits. It is safe for concurrent use.
+type lru struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int
+	bytes      int
+	ll         *list.List
+	items      map[string]*list.Element
+	hits       uint64
+	misses     uint64
+	evictions  uint64
+}
+
+type lruEntry struct {
+	name string
+	tpl  *cached
+}
+
+func newLRU() *lru {
+	return &lru{
+		ll: list.New(),
+		items: make(map[string]*list.Element, 15),
+	}
+}
+
+// get returns the cached template and marks it as recently used.
+func (c *lru) get(name string) (*cached, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[name]; ok {
+		c.hits++
+		c.ll.MoveToFront(e)
+		return e.Value.(*lruEntry).tpl, true
+	}
+	c.misses++
+	return nil, false
+}
+
+// peek returns the cached template without counting or marking it.
+func (c *lru) peek(name string) (*cached, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[name]; ok {
+		return e.Value.(*lruEntry).tpl, true
+	}
+	return nil, false
+}
+
+func (c *lru) add(name string, tpl *cached) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[name]; ok {
+		c.bytes -= e.Value.(*lruEntry).tpl.size
+		e.Value.(*lruEntry).tpl = tpl
+		c.bytes += tpl.size
+		c.ll.MoveToFront(e)
+	} else {
+		c.items[name] = c.ll.PushFront(&lruEntry{name: name, tpl: tpl})
+		c.bytes += tpl.size
+	}
+	c.evict()
+}
+
+// setLimits sets the max number of templates and the max size of their view
+// sources, zero means no limit.
+func (c *lru) setLimits(maxEntries, maxBytes int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxEntries = maxEntries
+	c.maxBytes = maxBytes
+	c.evict()
+}
+
+// evict removes the least recently used templates until the cache fits the
+// limits, the newest template is always kept.
+func (c *lru) evict() {
+	for c.ll.Len() > 1 && (c.maxEntries > 0 && c.ll.Len() > c.maxEntries || c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.removeElement(c.ll.Back())
+		c.evictions++
+	}
+}
+
+func (c *lru) removeElement(e *list.Element) {
+	entry := c.ll.Remove(e).(*lruEntry)
+	delete(c.items, entry.name)
+	c.bytes -= entry.tpl.size
+}
+
+// removeIf removes the templates which match f, returns the number of the
+// removed templates.
+func (c *lru) removeIf(f func(tpl *cached) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for e := c.ll.Front(); e != nil; {
+		next := e.Next()
+		if f(e.Value.(*lruEntry).tpl) {
+			c.removeElement(e)
+			removed++
+		}
+		e = next
+	}
+	return removed
+}
+
+// each calls f with every cached template.
+func (c *lru) each(f func(tpl *cached)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		f(e.Value.(*lruEntry).tpl)
+	}
+}
+
+// clear removes all templates, the limits and the counters are kept.
+func (c *lru) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element, 15)
+	c.bytes = 0
+}
+
+func (c *lru) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits: c.hits,
+		Misses: c.misses,
+		Evictions: c.evictions,
+		Templates: c.ll.Len(),
+		Bytes: c.bytes,
+	}
+}
@@ -0,0 +1,195 @@
+// Command view combines, checks and renders view files.
+//
+// Usage:
+//
+//	view combine [-ext .blade.php] <dir> <file> [file...]
+//	view check [-ext .blade.php] [-define] <dir>
+//	view render [-ext .blade.php] [-define] [-data data.json] <dir> <file> [file...]
+//	view build [-ext .blade.php] [-define] <dir> <out>
+//
+// "combine" prints the combined source of the files, multiple files are
+// merged by MergeHtml. "check" parses all pages of the directory with their
+// layouts and included files, and reports the errors with their positions,
+// see Container.PageFiles. "render" executes the files with the JSON data.
+// "build" renders the pages of the directory into a static site, see
+// Container.BuildSite.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"gopkg.in/orivil/view.v0"
+)
+
+const usage = `Usage:
+	view combine [-ext .blade.php] <dir> <file> [file...]
+	view check [-ext .blade.php] [-define] <dir>
+	view render [-ext .blade.php] [-define] [-data data.json] <dir> <file> [file...]
+	view build [-ext .blade.php] [-define] <dir> <out>
+`
+
+// errUsage means the command or its flags are unknown, the usage has been
+// printed.
+var errUsage = errors.New("bad usage")
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	if err != nil && err != errUsage && err != flag.ErrHelp {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(exitCode(err))
+}
+
+// exitCode returns the exit code of the error of run, 2 for the bad usage.
+func exitCode(err error) int {
+	switch err {
+	case nil, flag.ErrHelp:
+		return 0
+	case errUsage:
+		return 2
+	}
+	return 1
+}
+
+// run runs the command of the arguments, the output is written to stdout.
+func run(args []string, stdout io.Writer) error {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return errUsage
+	}
+	switch args[0] {
+	case "combine":
+		return combine(args[1:], stdout)
+	case "check":
+		return check(args[1:], stdout)
+	case "render":
+		return render(args[1:], stdout)
+	case "build":
+		return build(args[1:])
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return errUsage
+}
+
+func combine(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("combine", flag.ContinueOnError)
+	ext := flags.String("ext", ".blade.php", "the extension of view files")
+	args, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("combine needs a directory and files\n%s", usage)
+	}
+	html, err := view.NewContainer(true, *ext).Combine(pages(args)...)
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(html)
+	return err
+}
+
+func check(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	ext := flags.String("ext", ".blade.php", "the extension of view files")
+	define := flags.Bool("define", false, "compile the views in define mode")
+	args, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("check needs a directory\n%s", usage)
+	}
+	container := view.NewContainer(true, *ext)
+	container.SetDefineMode(*define)
+	// the layouts and partials are checked as parts of the pages
+	files, err := container.PageFiles(args[0])
+	if err != nil {
+		return err
+	}
+	err = container.Precompile(args[0], files...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%d pages are ok\n", len(files))
+	return nil
+}
+
+func render(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	ext := flags.String("ext", ".blade.php", "the extension of view files")
+	define := flags.Bool("define", false, "compile the views in define mode")
+	dataFile := flags.String("data", "", "the JSON file of the data")
+	args, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("render needs a directory and files\n%s", usage)
+	}
+	var data interface{}
+	if *dataFile != "" {
+		b, err := ioutil.ReadFile(*dataFile)
+		if err != nil {
+			return err
+		}
+		if err = json.Unmarshal(b, &data); err != nil {
+			return fmt.Errorf("Read data file %s got error: %v", *dataFile, err)
+		}
+	}
+	container := view.NewContainer(true, *ext)
+	container.SetDefineMode(*define)
+	container.SetBuffered(true)
+	return container.Display(stdout, data, pages(args)...)
+}
+
+func build(args []string) error {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	ext := flags.String("ext", ".blade.php", "the extension of view files")
+	define := flags.Bool("define", false, "compile the views in define mode")
+	args, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("build needs a directory and an output directory\n%s", usage)
+	}
+	container := view.NewContainer(false, *ext)
+	container.SetDefineMode(*define)
+	return container.BuildSite(args[0], args[1])
+}
+
+// parse parses the flags which may be mixed with the arguments, returns the
+// arguments. The flag set prints the bad flags, errUsage is returned then,
+// or flag.ErrHelp for the help flags.
+func parse(flags *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for {
+		if err := flags.Parse(args); err == flag.ErrHelp {
+			return nil, err
+		} else if err != nil {
+			return nil, errUsage
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return rest, nil
+		}
+		rest = append(rest, args[0])
+		args = args[1:]
+	}
+}
+
+// pages returns the pages of the files in the directory, args[0] is the
+// directory.
+func pages(args []string) []view.Page {
+	ps := make([]view.Page, len(args) - 1)
+	for i, file := range args[1:] {
+		ps[i] = view.NewPage(args[0], file)
+	}
+	return ps
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	data := filepath.Join(t.TempDir(), "data.json")
+	if err := ioutil.WriteFile(data, []byte(`"hello"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the flags may follow the arguments
+	for _, args := range [][]string{
+		{"render", "../../testdata", "index", "--data", data},
+		{"render", "-data", data, "../../testdata", "index"},
+	} {
+		buf := bytes.NewBuffer(nil)
+		if err := run(args, buf); err != nil {
+			t.Fatal(args, err)
+		}
+		if !strings.Contains(buf.String(), "<index>") || !strings.Contains(buf.String(), "hello") {
+			t.Errorf("%v got:\n%s", args, buf)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := run([]string{"check", "../../testdata/loop"}, buf)
+	if err != nil || buf.String() != "1 pages are ok\n" {
+		t.Errorf("got output: %q, got error: %v", buf, err)
+	}
+
+	err = run([]string{"check", "-define", "../../testdata/broken"}, ioutil.Discard)
+	if err == nil || exitCode(err) != 1 {
+		t.Fatalf("got error: %v, expect the errors of the broken views", err)
+	}
+	// the errors tell the positions
+	if !strings.Contains(err.Error(), "broken.blade.php:") {
+		t.Errorf("got error: %v", err)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"unknown"},
+		{"check", "-unknown", "../../testdata"},
+	} {
+		if err := run(args, ioutil.Discard); exitCode(err) != 2 {
+			t.Errorf("%v got error: %v, expect exit code 2", args, err)
+		}
+	}
+	if err := run([]string{"render", "../../testdata"}, ioutil.Discard); exitCode(err) != 1 {
+		t.Errorf("got error: %v, expect exit code 1", err)
+	}
+}
@@ -7,18 +7,132 @@
 package view
 
 import (
+	"io/fs"
 	"io/ioutil"
+	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"bytes"
+	"strings"
+	"strconv"
+	"sort"
+	"errors"
+	"fmt"
+	"time"
 )
 
+// CycleError means view files extend or include each other in a loop.
+type CycleError struct {
+	// The chain of file names, the last one closes the loop, e.g. a -> b -> a.
+	Files []string
+}
+
+func (ce *CycleError) Error() string {
+	return "Combine view file got a cycle: " + strings.Join(ce.Files, " -> ")
+}
+
+// CombineError is the error of combining a page, e.g. a missing layout or
+// included file, *CycleError and *IncludeError. It tells which directive of
+// which file got the error.
+type CombineError struct {
+	// The page which got the error, e.g. "views/index".
+	Page      string
+
+	// The position of the directive in the view file, e.g.
+	// "views/index.blade.php:3", it is invalid if the page itself can not
+	// be read.
+	Position  Position
+
+	// The directive which got the error, e.g. @include("partials/nav").
+	Directive string
+
+	// The original error.
+	Err       error
+}
+
+func (ce *CombineError) Error() string {
+	from := ce.Page
+	if ce.Position.IsValid() {
+		from = ce.Position.String()
+	}
+	if ce.Directive != "" {
+		return fmt.Sprintf("%v;\nDirective: %s\nError from: %s", ce.Err, ce.Directive, from)
+	}
+	return fmt.Sprintf("%v;\nError from: %s", ce.Err, from)
+}
+
+// Unwrap returns the original error.
+func (ce *CombineError) Unwrap() error {
+
+	return ce.Err
+}
+
+// combineError wraps the error of the directive in the line of the mark into
+// *CombineError, the errors of the nested directives are wrapped already.
+func (s *Combiner) combineError(err error, mark, directive []byte) error {
+	if _, ok := err.(*CombineError); ok {
+		return err
+	}
+	return &CombineError{
+		Page: filepath.Join(s.dir, s.file),
+		Position: markPosition(mark),
+		Directive: string(bytes.TrimSpace(stripMarks(directive))),
+		Err: err,
+	}
+}
+
+// link is a file of the chain of extending or including files.
+type link struct {
+	// The name used by the directive, e.g. "shared::partials.nav".
+	name string
+
+	// The path of the file.
+	file string
+}
+
+// appendChain appends the file to the chain, returns a *CycleError if the
+// chain already contains the file. The files are compared by their paths, for
+// the same name may resolve to different files, the names are only used by
+// the error.
+func appendChain(chain []link, name, file string) ([]link, error) {
+	for _, l := range chain {
+		if l.file == file {
+			files := make([]string, 0, len(chain) + 1)
+			for _, l := range chain {
+				files = append(files, l.name)
+			}
+			return nil, &CycleError{Files: append(files, name)}
+		}
+	}
+	return append(chain, link{name: name, file: file}), nil
+}
+
 type Combiner struct {
-	dir          string
-	ext          string
-	sections     map[string][]byte
-	layout       []byte
-	firstContent []byte
+	fsys           fs.FS
+	dir            string
+	ext            string
+	file           string
+	define         bool
+	sections       map[string][]byte
+	sectionDefines map[string][]byte
+	defines        map[string][]byte
+	layout         []byte
+	sourceMap      *SourceMap
+	files          []string
+	deps           map[string][]string
+	namespaces     map[string][]string
+	theme          string
+	resolved       map[string]string
+	frontMatter    bool
+
+	// The namespaces of the files resolved by namespaces.
+	origins        map[string]string
+
+	// The modification times of the files read by the last call of combine,
+	// they are only recorded if stat is set, see Container.Watch.
+	stat           bool
+	modTimes       map[string]time.Time
 }
 
 func NewCombiner(dir, ext string) *Combiner {
@@ -26,112 +140,694 @@ func NewCombiner(dir, ext string) *Combiner {
 		dir: dir,
 		ext: ext,
 		sections: make(map[string][]byte, 1),
+		resolved: make(map[string]string),
+		origins: make(map[string]string),
 	}
 }
 
+// NewFSCombiner returns a combiner which reads view files from the file
+// system, e.g. an embed.FS. The dir is a slash-separated path in fsys.
+func NewFSCombiner(fsys fs.FS, dir, ext string) *Combiner {
+	s := NewCombiner(dir, ext)
+	s.fsys = fsys
+	return s
+}
+
+// SetDefineMode sets whether the combiner works in define mode. In define mode
+// the included files and the sections are not pasted inline, every included
+// file turns into a {{define "dir/name"}} block named by the file path, e.g.
+// "views/partials/nav", and every section turns into a
+// {{define "dir/file#section"}} block, the directives turn into {{template}}
+// actions which execute the blocks with the current data. So the blocks can
+// be parsed once and shared by a template set.
+//
+// Note that template variables are not visible inside the blocks.
+func (s *Combiner) SetDefineMode(define bool) {
+
+	s.define = define
+}
+
+// SetNamespaces sets the directories of the view namespaces. A namespaced
+// name like "admin::layouts.main" is searched in the directories of the
+// namespace "admin" in order, the first existing "layouts/main" file wins, so
+// the views of a namespace can be overridden by adding a directory before its
+// own. The dots of namespaced names are path separators.
+//
+// The names without namespaces inside namespaced files are searched in the
+// directories of their namespace as well, so the views of a namespace can
+// include each other by relative names, e.g. "partials/nav", and the included
+// files can be overridden too.
+func (s *Combiner) SetNamespaces(namespaces map[string][]string) {
+
+	s.namespaces = namespaces
+}
+
+// SetTheme sets the theme directory which overrides the view files of the
+// combiner directory, the file, its layouts and included files are read from
+// the theme directory first, and fall back to the combiner directory. The
+// namespaced files are not affected.
+func (s *Combiner) SetTheme(dir string) {
+
+	s.theme = dir
+}
+
+// SetFrontMatter sets whether the YAML front matter between the "---" lines
+// at the top of the view files is removed, see Container.BuildSite. By default
+// the front matter is kept as the content of the files.
+func (s *Combiner) SetFrontMatter(frontMatter bool) {
+
+	s.frontMatter = frontMatter
+}
+
 // Combine combines section file into one full file.
 func Combine(dir, file, exe string) ([]byte, error) {
 
 	return NewCombiner(dir, exe).Combine(file)
 }
 
+// Combine reads the file and the whole chain of layouts it extends, e.g.
+// page -> section layout -> site layout -> base layout, the sections of a
+// child file take precedence over the sections of its layouts.
 func (s *Combiner) Combine(file string) ([]byte, error) {
-	content, err := s.getFileContent([]byte(file))
+	content, err := s.combine(file)
+	if err != nil {
+		return nil, err
+	}
+	content = append(content, compileDefines(s.sectionDefines)...)
+	content = append(content, compileDefines(s.defines)...)
+	content, s.sourceMap = unmark(content)
+	return content, nil
+}
+
+// SourceMap returns the source map of the content returned by the last call
+// of Combine, it tells which file and line every part of the content came
+// from.
+func (s *Combiner) SourceMap() *SourceMap {
+
+	return s.sourceMap
+}
+
+// Files returns the paths of the view files read by the last call of Combine,
+// the file itself, its layouts and the included files.
+func (s *Combiner) Files() []string {
+
+	return s.files
+}
+
+// Dependencies returns the dependency graph of the view files read by the
+// last call of Combine, it maps every file path to the paths of the layout
+// and the files it includes directly.
+func (s *Combiner) Dependencies() map[string][]string {
+
+	return s.deps
+}
+
+// addDependency records that the file extends or includes the dependency.
+func (s *Combiner) addDependency(file, dependency string) {
+	for _, d := range s.deps[file] {
+		if d == dependency {
+			return
+		}
+	}
+	s.deps[file] = append(s.deps[file], dependency)
+}
+
+// combine combines the file without the templates defined by sections and
+// "@include". The returned content and templates are marked, see mark.
+func (s *Combiner) combine(file string) ([]byte, error) {
+	s.sections = make(map[string][]byte, 1)
+	s.sectionDefines = make(map[string][]byte)
+	s.defines = make(map[string][]byte)
+	s.files = nil
+	s.deps = make(map[string][]string)
+	if s.stat {
+		s.modTimes = make(map[string]time.Time)
+	}
+	s.file = file
+	content, err := s.getFileContent([]byte(file), "")
+	if err != nil {
+		return nil, s.combineError(err, nil, nil)
+	}
+	current, _ := s.filename(file, "")
+	chain := []link{{name: file, file: current}}
+	for {
+		content = s.compileShow(content)
+		name, directive, layout, err := s.readLayout(content, current)
+		if err != nil {
+			return nil, s.combineError(err, lastMark(directive), directive)
+		}
+		if layout == nil {
+			break
+		}
+		layoutFile, _ := s.filename(name, current)
+		if chain, err = appendChain(chain, name, layoutFile); err != nil {
+			return nil, s.combineError(err, lastMark(directive), directive)
+		}
+		s.addDependency(current, layoutFile)
+		current = layoutFile
+		s.findSections(content, nil)
+		content = layout
+	}
+	// the root layout of the chain
+	s.layout = content
+	return s.merge()
+}
+
+// filename returns the path of the view file, the file of the theme wins, and
+// the namespaced file is searched in the directories of the namespace, see
+// SetTheme and SetNamespaces. from is the path of the file which extends or
+// includes the file, or "" for the page itself.
+func (s *Combiner) filename(file, from string) (string, error) {
+	idx := strings.Index(file, "::")
+	if ns, ok := s.origins[from]; ok && idx == -1 {
+		// relative to the namespace of the namespaced file
+		return s.search(ns, file)
+	}
+	if filename, ok := s.resolved[file]; ok {
+		return filename, nil
+	}
+	if idx == -1 {
+		if s.theme != "" {
+			if filename := s.join(s.theme, file); s.exists(filename) {
+				s.resolved[file] = filename
+				return filename, nil
+			}
+		}
+		return s.join(s.dir, file), nil
+	}
+	if _, ok := s.namespaces[file[:idx]]; !ok {
+		return "", fmt.Errorf("Combine view file got an unknown namespace: %s", file)
+	}
+	filename, err := s.search(file[:idx], strings.Replace(file[idx + 2:], ".", "/", -1))
+	if err != nil {
+		return "", err
+	}
+	s.resolved[file] = filename
+	return filename, nil
+}
+
+// search returns the path of the first existing file of the name in the
+// directories of the namespace.
+func (s *Combiner) search(ns, name string) (string, error) {
+	dirs := s.namespaces[ns]
+	for _, dir := range dirs {
+		filename := s.join(dir, name)
+		if s.exists(filename) {
+			s.origins[filename] = ns
+			return filename, nil
+		}
+	}
+	return "", fmt.Errorf("Combine view file can not find %s::%s in: %s", ns, name, strings.Join(dirs, ", "))
+}
+
+func (s *Combiner) join(dir, name string) string {
+	if s.fsys != nil {
+		return path.Join(dir, name + s.ext)
+	}
+	return filepath.Join(dir, name + s.ext)
+}
+
+func (s *Combiner) exists(filename string) bool {
+	var info fs.FileInfo
+	var err error
+	if s.fsys != nil {
+		info, err = fs.Stat(s.fsys, filename)
+	} else {
+		info, err = os.Stat(filename)
+	}
+	return err == nil && !info.IsDir()
+}
+
+// modTime returns the modification time of the file, or the zero time if the
+// file can not be stat.
+func (s *Combiner) modTime(filename string) time.Time {
+	var info fs.FileInfo
+	var err error
+	if s.fsys != nil {
+		info, err = fs.Stat(s.fsys, filename)
+	} else {
+		info, err = os.Stat(filename)
+	}
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func (s *Combiner) getFileContent(file []byte, from string) ([]byte, error) {
+	var content []byte
+	filename, err := s.filename(string(file), from)
 	if err != nil {
 		return nil, err
 	}
-	err = s.readLayout(content)
+	if _, ok := s.modTimes[filename]; s.stat && !ok {
+		// stat before reading, so a file saved while reading is never
+		// taken as unchanged
+		s.modTimes[filename] = s.modTime(filename)
+	}
+	if s.fsys != nil {
+		content, err = fs.ReadFile(s.fsys, filename)
+	} else {
+		content, err = ioutil.ReadFile(filename)
+	}
 	if err != nil {
 		return nil, err
 	}
-	s.findSections(content)
-	s.firstContent = content
-	return s.merge()
+	s.addFile(filename)
+	lines := 0
+	if s.frontMatter {
+		_, content, lines = splitFrontMatter(content)
+	}
+	return mark(content, filename, lines + 1), nil
 }
 
-func (s *Combiner) getFileContent(file []byte) ([]byte, error) {
+func (s *Combiner) addFile(filename string) {
+	for _, f := range s.files {
+		if f == filename {
+			return
+		}
+	}
+	s.files = append(s.files, filename)
+}
+
+// @include("name") or @include("name", pipeline), the pipeline may be an
+// object like {"title": .Item.Name}
+var includePatten = regexp.MustCompile(`@include\(\s*["']([\w\/\.\-\_:]+)["']\s*`)
+
+// compileInclude replaces all "@include" of the content with the included
+// files, the included files are compiled recursively. chain holds the files
+// being included, for detecting include cycles.
+func (s *Combiner) compileInclude(content []byte, chain []link) ([]byte, error) {
+	var done, mark []byte
+	for {
+		loc := includePatten.FindSubmatchIndex(content)
+		if loc == nil {
+			break
+		}
+		name := string(content[loc[2]:loc[3]])
+		args, n := readIncludeArgs(content[loc[1]:])
+		if n == -1 {
+			// not a complete directive, keep it as text
+			done = append(done, content[:loc[1]]...)
+			content = content[loc[1]:]
+			continue
+		}
+		done = append(done, content[:loc[0]]...)
+		directive := content[loc[0]:loc[1] + n]
+		if m := lastMark(content[:loc[1] + n]); m != nil {
+			mark = m
+		}
+		content = content[loc[1] + n:]
+		// the mark tells which file includes the name
+		from := markPosition(mark).File
+		if from != "" {
+			if to, err := s.filename(name, from); err == nil {
+				s.addDependency(from, to)
+			}
+		}
 
-	return ioutil.ReadFile(filepath.Join(s.dir, string(file) + s.ext))
+		var c []byte
+		var err error
+		if args == nil && s.define {
+			c, err = s.includeTemplate(name, from, []byte("."), directive, chain)
+		} else if args == nil {
+			c, err = s.includeFile(name, from, chain)
+		} else {
+			c, err = s.includeTemplate(name, from, args, directive, nil)
+		}
+		if err != nil {
+			return nil, s.combineError(err, mark, directive)
+		}
+		done = append(done, c...)
+		// the rest of the line keeps its position
+		done = append(done, mark...)
+	}
+	return append(done, content...), nil
 }
 
-var includePatten = regexp.MustCompile(`@include\(["']([\w\/\.\-\_]+)["']\)`)
+// includeFile reads the included file and compiles its includes, from is the
+// path of the including file.
+func (s *Combiner) includeFile(name, from string, chain []link) ([]byte, error) {
+	filename, err := s.filename(name, from)
+	if err != nil {
+		return nil, err
+	}
+	next, err := appendChain(chain, name, filename)
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.getFileContent([]byte(name), from)
+	if err != nil {
+		return nil, err
+	}
+	return s.compileInclude(c, next)
+}
 
-func (s *Combiner) compileInclude(content []byte) ([]byte, error) {
-	result := includePatten.FindAllSubmatch(content, -1)
-	for _, r := range result {
-		name := r[1]
-		c, err := s.getFileContent(name)
+// includeTemplate defines the included file as a named template and returns
+// the "{{template}}" action which executes it with the pipeline args. chain is
+// nil for the parameterized includes, which may include themselves, e.g. a
+// tree menu, the other includes are checked for cycles.
+func (s *Combiner) includeTemplate(name, from string, args, directive []byte, chain []link) ([]byte, error) {
+	data, err := includeData(stripMarks(args))
+	if err != nil {
+		return nil, &IncludeError{Directive: string(stripMarks(directive)), Err: err.Error()}
+	}
+	filename, err := s.filename(name, from)
+	if err != nil {
+		return nil, err
+	}
+	if chain != nil {
+		if _, err := appendChain(chain, name, filename); err != nil {
+			return nil, err
+		}
+	}
+	define := s.includeName(filename)
+	if _, ok := s.defines[define]; !ok {
+		// the template may include itself by parameters
+		s.defines[define] = nil
+		c, err := s.includeFile(name, from, chain)
 		if err != nil {
 			return nil, err
 		}
-		content = bytes.Replace(content, r[0], c, 1)
+		s.defines[define] = c
 	}
-	return content, nil
+	return []byte("{{template " + strconv.Quote(define) + " " + string(data) + "}}"), nil
+}
+
+// includeName returns the template name of the included file, e.g.
+// "views/partials/nav", so the included files of the same name in different
+// directories never share a template.
+func (s *Combiner) includeName(filename string) string {
+
+	return strings.TrimSuffix(filepath.ToSlash(filename), s.ext)
+}
+
+// IncludeError means the arguments of an "@include" directive are malformed.
+type IncludeError struct {
+	// The whole directive, e.g. @include("card", {"title" .Name}).
+	Directive string
+
+	// The error message.
+	Err       string
+}
+
+func (ie *IncludeError) Error() string {
+	return "Combine view file got a bad include: " + ie.Directive + ": " + ie.Err
+}
+
+// readIncludeArgs reads the optional arguments from the rest of the "@include"
+// directive, e.g. `)` or `, .Item)`. n is the length of the rest directive,
+// or -1 if the directive is not closed.
+func readIncludeArgs(rest []byte) (args []byte, n int) {
+	if len(rest) == 0 {
+		return nil, -1
+	}
+	if rest[0] == ')' {
+		return nil, 1
+	}
+	if rest[0] != ',' {
+		return nil, -1
+	}
+	end := indexTop(rest[1:], ')')
+	if end == -1 {
+		return nil, -1
+	}
+	args = bytes.TrimSpace(rest[1:end + 1])
+	if len(args) == 0 {
+		return nil, end + 2
+	}
+	return args, end + 2
+}
+
+// includeData turns the "@include" arguments into a template pipeline, an
+// object like {"title": .Item.Name} turns into (dict "title" (.Item.Name)).
+func includeData(args []byte) ([]byte, error) {
+	if args[0] != '{' {
+		return args, nil
+	}
+	if args[len(args) - 1] != '}' {
+		return nil, errors.New("object is not closed")
+	}
+	buf := bytes.NewBufferString("(dict")
+	entries := args[1:len(args) - 1]
+	for len(bytes.TrimSpace(entries)) > 0 {
+		entry := entries
+		entries = nil
+		if i := indexTop(entry, ','); i != -1 {
+			entry, entries = entry[:i], entry[i + 1:]
+		}
+		colon := indexTop(entry, ':')
+		if colon == -1 {
+			return nil, fmt.Errorf("missing ':' in %q", bytes.TrimSpace(entry))
+		}
+		key := string(bytes.TrimSpace(entry[:colon]))
+		if k, err := strconv.Unquote(key); err == nil {
+			key = k
+		}
+		value := bytes.TrimSpace(entry[colon + 1:])
+		if key == "" || len(value) == 0 {
+			return nil, fmt.Errorf("empty key or value in %q", bytes.TrimSpace(entry))
+		}
+		fmt.Fprintf(buf, " %s (%s)", strconv.Quote(key), value)
+	}
+	buf.WriteString(")")
+	return buf.Bytes(), nil
+}
+
+// indexTop returns the index of the first c which is not nested in brackets
+// or quotes, or -1 if there is none. Marks are skipped.
+func indexTop(b []byte, c byte) int {
+	var depth int
+	var quote byte
+	for i := 0; i < len(b); i++ {
+		switch {
+		case b[i] == markStart:
+			for i < len(b) && b[i] != markEnd {
+				i++
+			}
+		case quote != 0:
+			if b[i] == '\\' && quote != '`' {
+				i++
+			} else if b[i] == quote {
+				quote = 0
+			}
+		case b[i] == c && depth == 0:
+			return i
+		case b[i] == '"' || b[i] == '\'' || b[i] == '`':
+			quote = b[i]
+		case b[i] == '(' || b[i] == '[' || b[i] == '{':
+			depth++
+		case b[i] == ')' || b[i] == ']' || b[i] == '}':
+			depth--
+		}
+	}
+	return -1
 }
 
-var yieldPatten = regexp.MustCompile(`@yield\(["']([\w]+)["']\)`)
+// @yield("name") or @yield("name", "default content")
+var yieldPatten = regexp.MustCompile(`@yield\(["']([\w]+)["'](?:\s*,\s*(?:"([^"]*)"|'([^']*)'))?\)`)
+
+// @hasSection("name") ... @endif or @sectionMissing("name") ... @endif
+var sectionIfPatten = regexp.MustCompile(`@(hasSection|sectionMissing)\(["']([\w]+)["']\)([\s\S]*?)@endif\b`)
 
 // merge all files
 func (s *Combiner) merge() ([]byte, error) {
-	if s.layout == nil {
-		return s.compileInclude(s.firstContent)
-	} else {
-		result := yieldPatten.FindAllSubmatch(s.layout, -1)
-		for _, r := range result {
-			name := r[1]
-			replace := []byte{}
-			if section, ok := s.sections[string(name)]; ok {
-				replace = section
+	content := s.compileYield(s.layout, nil)
+	file, _ := s.filename(s.file, "")
+	chain := []link{{name: s.file, file: file}}
+	for name, section := range s.sectionDefines {
+		c, err := s.compileInclude(section, chain)
+		if err != nil {
+			return nil, err
+		}
+		s.sectionDefines[name] = c
+	}
+	return s.compileInclude(content, chain)
+}
+
+// compileDefines returns the templates in the form of
+// {{define "name"}}...{{end}}, sorted by names.
+func compileDefines(defines map[string][]byte) []byte {
+	names := make([]string, 0, len(defines))
+	for name := range defines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf []byte
+	for _, name := range names {
+		buf = append(buf, "\n{{define " + strconv.Quote(name) + "}}"...)
+		buf = append(buf, defines[name]...)
+		buf = append(buf, "{{end}}"...)
+	}
+	return buf
+}
+
+// compileYield replaces all "@yield" of the content with sections, a section
+// defined by a middle layout may also yield the sections of its child pages.
+// The default content of "@yield" and the "@sectionMissing" blocks are used
+// if no file defined the section, so layouts can also be combined standalone.
+func (s *Combiner) compileYield(content []byte, yielding []string) []byte {
+	content = replaceMarked(sectionIfPatten, content, func(r [][]byte, mark []byte) []byte {
+		_, ok := s.sections[string(r[2])]
+		if ok == (string(r[1]) == "hasSection") {
+			return trimSection(r[3], mark)
+		}
+		return []byte{}
+	})
+	return replaceMarked(yieldPatten, content, func(r [][]byte, mark []byte) []byte {
+		name := string(r[1])
+		section, ok := s.sections[name]
+		if !ok {
+			// default content quoted by `"` or `'`
+			if r[2] != nil {
+				return r[2]
 			}
-			s.layout = bytes.Replace(s.layout, r[0], replace, -1)
+			return r[3]
+		}
+		for _, y := range yielding {
+			// section yields itself
+			if y == name {
+				return []byte{}
+			}
+		}
+		// "@parent" of the root section has nothing to splice
+		section = parentPatten.ReplaceAll(section, []byte{})
+		section = s.compileYield(section, append(yielding, name))
+		if s.define {
+			define := s.sectionName(name)
+			s.sectionDefines[define] = section
+			return []byte("{{template " + strconv.Quote(define) + " .}}")
 		}
+		return section
+	})
+}
+
+// sectionName returns the template name of the section in define mode, e.g.
+// "views/index#content".
+func (s *Combiner) sectionName(section string) string {
+
+	return path.Join(filepath.ToSlash(s.dir), s.file) + "#" + section
+}
+
+var sectionStartPatten = regexp.MustCompile(`@section\(["']([\w]+)["']\)`)
+var sectionEndPatten = regexp.MustCompile(`@(endsection|show)\b`)
+
+// compileShow turns the "@section ... @show" blocks of the content into
+// "@yield", the content of the blocks are added as sections of current file,
+// so the blocks show their default content unless child files cover them.
+func (s *Combiner) compileShow(content []byte) []byte {
+	var done, mark []byte
+	for {
+		end := sectionEndPatten.FindSubmatchIndex(content)
+		if end == nil {
+			break
+		}
+		// the closest section start belongs to the first end tag
+		starts := sectionStartPatten.FindAllSubmatchIndex(content[:end[0]], -1)
+		if len(starts) == 0 || string(content[end[2]:end[3]]) != "show" {
+			if m := lastMark(content[:end[1]]); m != nil {
+				mark = m
+			}
+			done = append(done, content[:end[1]]...)
+			content = content[end[1]:]
+			continue
+		}
+		start := starts[len(starts) - 1]
+		name := string(content[start[2]:start[3]])
+		startMark := mark
+		if m := lastMark(content[:start[0]]); m != nil {
+			startMark = m
+		}
+		s.addSection(name, trimSection(content[start[1]:end[0]], startMark))
+		block := []byte(`@yield("` + name + `")`)
+		// the rest of the line after "@show" keeps its position
+		if m := lastMark(content[start[0]:end[1]]); m != nil {
+			block = append(block, m...)
+		}
+		block = append(block, content[end[1]:]...)
+		// copy the prefix, for the section content still refers to it
+		content = append(content[:start[0]:start[0]], block...)
+	}
+	return append(done, content...)
+}
+
+var parentPatten = regexp.MustCompile(`@parent`)
+
+// addSection adds a section of current file. If a child file already defined
+// the section, the child section covers it, and "@parent" of the child section
+// will be replaced with it.
+func (s *Combiner) addSection(name string, content []byte) {
+	if child, ok := s.sections[name]; ok {
+		s.sections[name] = replaceMarked(parentPatten, child, func([][]byte, []byte) []byte {
+			return content
+		})
+	} else {
+		s.sections[name] = content
 	}
-	return s.compileInclude(s.layout)
 }
 
-var extendsPatten = regexp.MustCompile(`^\s*@extends\(["']([\w\/\.\-\_]+)["']\)`)
+var extendsPatten = regexp.MustCompile(`^\s*(?:` + markPatten + `)?@extends\(["']([\w\/\.\-\_:]+)["']\)`)
 
-// read section extends layout file name and get the layout content
-func (s *Combiner) readLayout(content []byte) error {
+// read section extends layout file name and get the layout content, returns
+// nil if the content extends nothing. from is the path of the file, the
+// directive is the marked "@extends" of the content.
+func (s *Combiner) readLayout(content []byte, from string) (name string, directive, layout []byte, err error) {
 	result := extendsPatten.FindAllSubmatch(content, -1)
 	if len(result) > 0 {
-		name := result[0][1]
-		c, err := s.getFileContent(name)
-		if err != nil {
-			return err
-		}
-		s.layout = c
+		name, directive = string(result[0][1]), result[0][0]
+		layout, err = s.getFileContent([]byte(name), from)
 	}
-	return nil
+	return
 }
 
 var endsectionPatten = regexp.MustCompile(`@endsection\s*$`)
 var sectionPatten = regexp.MustCompile(`@section\(["']([\w]+)["']\)([\s\S]+)@endsection`)
-var prefixPatten = regexp.MustCompile(`^[\s\n]*`)
-var suffixPatten = regexp.MustCompile(`[\s\n]*$`)
+var prefixPatten = regexp.MustCompile(`^(?:[\s\n]|` + markPatten + `)*`)
+var suffixPatten = regexp.MustCompile(`(?:[\s\n]|` + markPatten + `)*$`)
 
-func (s *Combiner) findSections(content []byte) {
+// trimSection trims the spaces and marks around the section content, but
+// keeps the mark of the first line. If the first line has no mark, the
+// section starts right after a directive in the line of the mark.
+func trimSection(content, mark []byte) []byte {
+	content = suffixPatten.ReplaceAll(content, []byte{})
+	prefix := prefixPatten.Find(content)
+	if m := lastMark(prefix); m != nil {
+		mark = m
+	}
+	return withMark(mark, content[len(prefix):])
+}
+
+// findSections finds all sections of the content, mark is the mark of the
+// line where the content starts.
+func (s *Combiner) findSections(content, mark []byte) {
 	// auto add close tag
 	if !endsectionPatten.Match(content) {
 
-		content = append(content, []byte("@endsection")...)
+		content = append(content[:len(content):len(content)], []byte("@endsection")...)
 	}
 
-	result := sectionPatten.FindAllSubmatch(content, -1)
-	if len(result) > 0 {
-		name := string(result[0][1])
-		matched := result[0][2]
-		matched = prefixPatten.ReplaceAll(matched, []byte{})
-		matched = suffixPatten.ReplaceAll(matched, []byte{})
+	loc := sectionPatten.FindSubmatchIndex(content)
+	if loc != nil {
+		if m := lastMark(content[:loc[0]]); m != nil {
+			mark = m
+		}
+		name := string(content[loc[2]:loc[3]])
+		matched := content[loc[4]:loc[5]]
 
 		// get first section
 		index := bytes.Index(matched, []byte("@endsection"))
-		if index == -1 {
-			s.sections[name] = matched
-		} else {
-			s.sections[name] = matched[0: index]
+		var next []byte
+		if index != -1 {
+			next = matched[index:]
+			matched = matched[0: index]
+		}
+		s.addSection(name, trimSection(matched, mark))
+		if next != nil {
+			if m := lastMark(matched); m != nil {
+				mark = m
+			}
 			// find next section
-			s.findSections(matched[index:])
+			s.findSections(next, mark)
 		}
 	}
 }
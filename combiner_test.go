@@ -0,0 +1,221 @@
+package view_test
+
+import (
+	"testing"
+	"reflect"
+	"bytes"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing/fstest"
+	"gopkg.in/orivil/view.v0"
+)
+
+func TestCombinerInclude(t *testing.T) {
+	content, err := view.NewCombiner("./testdata/include", fileExt).Combine("index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "<ul>\n    <li>{{.}}</li>\n    <li>{{.}}</li>\n</ul>"
+	if string(content) != expect {
+		t.Error("got:", string(content), "expect:", expect)
+	}
+}
+
+var cycleTestData = map[string][]string{
+	"a": {"a", "b", "a"},
+	"self": {"self", "self"},
+	"x": {"x", "y", "x"},
+}
+
+func TestCombinerCycle(t *testing.T) {
+	for _, define := range []bool{false, true} {
+		for file, files := range cycleTestData {
+			combiner := view.NewCombiner("./testdata/cycle", fileExt)
+			combiner.SetDefineMode(define)
+			_, err := combiner.Combine(file)
+			var ce *view.CycleError
+			if !errors.As(err, &ce) {
+				t.Errorf("%s: define mode %v got error: %v, expect *view.CycleError", file, define, err)
+				continue
+			}
+			if !reflect.DeepEqual(ce.Files, files) {
+				t.Error("got files:", ce.Files, "expect files:", files)
+			}
+		}
+	}
+}
+
+func TestCombinerParent(t *testing.T) {
+	content, err := view.NewCombiner("./testdata/parent", fileExt).Combine("child")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `<head>
+    <script src="/app.js"></script>
+    <script src="/page.js"></script>
+    <script src="/child.js"></script>
+</head>
+<nav><a>child</a></nav>`
+	if string(content) != expect {
+		t.Error("got:", string(content), "expect:", expect)
+	}
+}
+
+func TestCombinerIncludeParameters(t *testing.T) {
+	content, err := view.NewCombiner("./testdata/components", fileExt).Combine("index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `<ul>
+{{range .Items}}    {{template "testdata/components/partials/card" (dict "title" (.Name) "price" ((printf "%.2f" .Price)))}}
+{{end}}</ul>
+{{template "testdata/components/partials/badge" .Badge}}
+{{define "testdata/components/partials/badge"}}<b>{{.}}</b>{{end}}
+{{define "testdata/components/partials/card"}}<li>{{.title}}: {{.price}}</li>{{end}}`
+	if string(content) != expect {
+		t.Error("got:", string(content), "expect:", expect)
+	}
+}
+
+func TestCombinerBadInclude(t *testing.T) {
+	_, err := view.NewCombiner("./testdata/components", fileExt).Combine("bad")
+	var ie *view.IncludeError
+	if !errors.As(err, &ie) {
+		t.Fatalf("got error: %v, expect *view.IncludeError", err)
+	}
+	expect := `@include("partials/card", {"title" .Name})`
+	if ie.Directive != expect {
+		t.Error("got directive:", ie.Directive, "expect directive:", expect)
+	}
+}
+
+func TestCombinerMissingFile(t *testing.T) {
+	views := fstest.MapFS{
+		"a.blade.php": {Data: []byte("<p>\n    @include(\"b\")\n</p>")},
+		"c.blade.php": {Data: []byte("@extends(\"layout\")")},
+	}
+	cases := []struct {
+		file      string
+		directive string
+		position  view.Position
+	}{
+		{"a", `@include("b")`, view.Position{File: "a.blade.php", Line: 2}},
+		{"c", `@extends("layout")`, view.Position{File: "c.blade.php", Line: 1}},
+		{"d", "", view.Position{}},
+	}
+	for _, define := range []bool{false, true} {
+		for _, c := range cases {
+			combiner := view.NewFSCombiner(views, ".", fileExt)
+			combiner.SetDefineMode(define)
+			_, err := combiner.Combine(c.file)
+			var ce *view.CombineError
+			if !errors.As(err, &ce) || !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("%s: define mode %v got error: %v, expect *view.CombineError of the missing file", c.file, define, err)
+				continue
+			}
+			// the error tells which directive of which file is broken
+			if ce.Page != c.file || ce.Directive != c.directive || ce.Position != c.position {
+				t.Errorf("%s: got page: %s, directive: %s, position: %v", c.file, ce.Page, ce.Directive, ce.Position)
+			}
+		}
+	}
+}
+
+func TestCombinerDefineMode(t *testing.T) {
+	combiner := view.NewCombiner("./testdata/nested", fileExt)
+	combiner.SetDefineMode(true)
+	content, err := combiner.Combine("index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `<html>
+<title>{{template "testdata/nested/index#title" .}}</title>
+<body>
+    {{template "testdata/nested/index#content" .}}
+</body>
+</html>
+{{define "testdata/nested/index#content"}}<shop>
+        {{template "testdata/nested/index#main" .}}
+    </shop>{{end}}
+{{define "testdata/nested/index#main"}}<cart>{{.}}</cart>{{end}}
+{{define "testdata/nested/index#title"}}Cart{{end}}`
+	if string(content) != expect {
+		t.Error("got:", string(content), "expect:", expect)
+	}
+}
+
+func TestCombinerDependencies(t *testing.T) {
+	file := func(dir, name string) string {
+		return filepath.Join(dir, name + fileExt)
+	}
+	combiner := view.NewCombiner("./testdata/include", fileExt)
+	if _, err := combiner.Combine("index"); err != nil {
+		t.Fatal(err)
+	}
+	dir := "./testdata/include"
+	expect := map[string][]string{
+		file(dir, "index"): {file(dir, "partials/nav")},
+		file(dir, "partials/nav"): {file(dir, "partials/item")},
+	}
+	if got := combiner.Dependencies(); !reflect.DeepEqual(got, expect) {
+		t.Errorf("got dependencies: %v\nexpect: %v", got, expect)
+	}
+
+	dir = "./testdata/nested"
+	combiner = view.NewCombiner(dir, fileExt)
+	if _, err := combiner.Combine("index"); err != nil {
+		t.Fatal(err)
+	}
+	expect = map[string][]string{
+		file(dir, "index"): {file(dir, "layouts/shop")},
+		file(dir, "layouts/shop"): {file(dir, "layouts/base")},
+	}
+	if got := combiner.Dependencies(); !reflect.DeepEqual(got, expect) {
+		t.Errorf("got dependencies: %v\nexpect: %v", got, expect)
+	}
+}
+
+func TestCombinerNamespaces(t *testing.T) {
+	combiner := view.NewCombiner("", fileExt)
+	combiner.SetNamespaces(map[string][]string{
+		"admin": {"./testdata/namespaces/admin"},
+		"shared": {"./testdata/namespaces/shared"},
+	})
+	content, err := combiner.Combine("admin::users.index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(content, []byte("<nav>shared</nav>")) {
+		t.Errorf("got content:\n%s", content)
+	}
+	// the names without namespaces are relative to the namespace directory
+	content, err = combiner.Combine("admin::users.show")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "<p>card</p>\n"; string(content) != expect {
+		t.Errorf("got content: %q, expect: %q", content, expect)
+	}
+	// the same name in other directories is not a cycle
+	for _, define := range []bool{false, true} {
+		combiner := view.NewCombiner("./testdata/namespaces/app", fileExt)
+		combiner.SetDefineMode(define)
+		combiner.SetNamespaces(map[string][]string{
+			"shared": {"./testdata/namespaces/shared"},
+		})
+		content, err := combiner.Combine("index")
+		if err != nil {
+			t.Fatalf("define mode %v got error: %v", define, err)
+		}
+		if !bytes.Contains(content, []byte("<li>shared</li>")) {
+			t.Errorf("define mode %v got content:\n%s", define, content)
+		}
+	}
+	if _, err := combiner.Combine("blog::index"); err == nil {
+		t.Error("expect an error of the unknown namespace")
+	}
+	if _, err := combiner.Combine("shared::partials.none"); err == nil {
+		t.Error("expect an error of the missing file")
+	}
+}
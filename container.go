@@ -2,6 +2,7 @@ package view
 
 import (
 	"html/template"
+	"io/fs"
 	"sync"
 	"io"
 	"path/filepath"
@@ -11,63 +12,313 @@ import (
 	"strings"
 	"bufio"
 	"fmt"
+	"errors"
+	"time"
 )
 
 type ParseError struct {
-	// Because it is hard to find the line number from a merged file,
-	// so just return a text line.
+	// The text line of the merged file which got the error.
 	Line  string
 
+	// The position of the line in the view file, e.g.
+	// "views/layouts/base.blade.php:42".
+	Position Position
+
 	// The original error message.
 	Err   string
 
+	// The name of the template which got the error in define mode, e.g.
+	// the name of an included file.
+	Template string
+
 	// The error may come from multiple pages.
 	Pages []Page
 }
 
 func (pe *ParseError) Error() string {
 	var pages string
-	for _, p := range pe.Pages {
-		pages += "\n" + filepath.Join(p.Dir, p.File)
+	if pe.Position.IsValid() {
+		pages = pe.Position.String()
+	} else {
+		for _, p := range pe.Pages {
+			pages += "\n" + filepath.Join(p.Dir, p.File)
+		}
+	}
+	if pe.Template != "" {
+		return fmt.Sprintf("Parse view file got error: %s;\nTemplate: %s\nText line: %s\nError from: %s", pe.Err, pe.Template, pe.Line, pages)
 	}
 	return fmt.Sprintf("Parse view file got error: %s;\nText line: %s\nError from: %s", pe.Err, pe.Line, pages)
 }
 
+// ExecuteError is the error of executing a view template, e.g. evaluating a
+// field of nil pointer.
+type ExecuteError struct {
+	// The name of the template which got the error.
+	Template string
+
+	// The offending template action, e.g. "<.User.Name>".
+	Action   string
+
+	// The position of the action in the view file.
+	Position Position
+
+	// The lines around the action.
+	Lines    []SourceLine
+
+	// The original error message.
+	Err      string
+
+	// The error may come from multiple pages.
+	Pages    []Page
+
+	err      error
+}
+
+func (ee *ExecuteError) Error() string {
+	var from string
+	if ee.Position.IsValid() {
+		from = ee.Position.String()
+	} else {
+		for _, p := range ee.Pages {
+			from += "\n" + filepath.Join(p.Dir, p.File)
+		}
+	}
+	var lines string
+	for _, l := range ee.Lines {
+		if l.Position == ee.Position {
+			lines += "\n> "
+		} else {
+			lines += "\n  "
+		}
+		lines += l.Position.String() + " | " + l.Text
+	}
+	return fmt.Sprintf("Execute view file got error: %s;\nAction: %s\nError from: %s%s", ee.Err, ee.Action, from, lines)
+}
+
+// Unwrap returns the original error of the template.
+func (ee *ExecuteError) Unwrap() error {
+
+	return ee.err
+}
+
 type Container struct {
-	tpls    map[string]*template.Template
-	ext     string
-	debug   bool
-	handler func(tpl *template.Template)
-	rwmu    *sync.RWMutex
+	fsys     fs.FS
+	tpls     *lru
+	partials map[string]*cached
+	building map[string]*building
+
+	// The dependency graph of the view files of all built templates, it is
+	// kept when the templates are removed from the cache.
+	deps     map[string][]string
+
+	// Increased whenever cached templates are removed.
+	generation int
+	ext      string
+	debug    bool
+	define   bool
+	buffered bool
+	watching bool
+	namespaces map[string][]string
+	handler  func(tpl *template.Template)
+	errorHandler func(w io.Writer, err error)
+
+	// Guards the caches and the configuration, the setters must hold it
+	// because they can be called while displaying.
+	rwmu     *sync.RWMutex
 }
 
 // If debug is true, combiner will always read view file from disk,
-// otherwise it will cache the Template objects.
+// otherwise it will cache the Template objects. See Watch for reloading only
+// the changed view files.
 func NewContainer(debug bool, ext string) *Container {
 
 	return &Container{
-		tpls: make(map[string]*template.Template, 15),
+		tpls: newLRU(),
+		partials: make(map[string]*cached),
+		building: make(map[string]*building),
+		deps: make(map[string][]string),
 		debug: debug,
 		ext: ext,
 		rwmu: &sync.RWMutex{},
 	}
 }
 
+// NewFSContainer returns a container which reads view files from the file
+// system, e.g. an embed.FS or a fstest.MapFS, the Dir of pages are
+// slash-separated paths in fsys.
+func NewFSContainer(fsys fs.FS, debug bool, ext string) *Container {
+	c := NewContainer(debug, ext)
+	c.fsys = fsys
+	return c
+}
+
+// newCombiner returns a combiner of the dir and theme, it records the
+// modification times of the files if stat is true.
+func (this *Container) newCombiner(dir, theme string, stat bool) *Combiner {
+	var combiner *Combiner
+	if this.fsys != nil {
+		combiner = NewFSCombiner(this.fsys, dir, this.ext)
+	} else {
+		combiner = NewCombiner(dir, this.ext)
+	}
+	combiner.SetTheme(theme)
+	combiner.stat = stat
+	this.rwmu.RLock()
+	combiner.SetNamespaces(this.namespaces)
+	this.rwmu.RUnlock()
+	return combiner
+}
+
+// AddNamespace adds the search directories of the view namespace, e.g.
+// AddNamespace("admin", "app/views/admin", "vendor/admin/views") makes
+// "admin::layouts.main" search "app/views/admin/layouts/main" first. The
+// directories added earlier take precedence, see PrependNamespace. The
+// namespaced names can be used by "@extends", "@include" and the File of
+// pages, the Dir of the page is not needed then, e.g.
+// NewPage("", "admin::users.index"). The cached templates are cleared, for
+// the names may resolve to other files now.
+func (this *Container) AddNamespace(name string, dirs ...string) {
+
+	this.setNamespace(name, dirs, false)
+}
+
+// PrependNamespace adds the search directories of the view namespace before
+// the directories added earlier, so the application can override the views
+// of a namespace added by a plugin, e.g.
+// PrependNamespace("admin", "app/views/vendor/admin").
+func (this *Container) PrependNamespace(name string, dirs ...string) {
+
+	this.setNamespace(name, dirs, true)
+}
+
+// setNamespace adds the dirs after or before the directories of the
+// namespace.
+func (this *Container) setNamespace(name string, dirs []string, prepend bool) {
+	this.rwmu.Lock()
+	// the combiners share the map, copy on write
+	namespaces := make(map[string][]string, len(this.namespaces) + 1)
+	for ns, nsDirs := range this.namespaces {
+		namespaces[ns] = nsDirs
+	}
+	if prepend {
+		namespaces[name] = append(append([]string(nil), dirs...), namespaces[name]...)
+	} else {
+		namespaces[name] = append(append([]string(nil), namespaces[name]...), dirs...)
+	}
+	this.namespaces = namespaces
+	this.tpls.clear()
+	this.partials = make(map[string]*cached)
+	this.generation++
+	this.rwmu.Unlock()
+}
+
+// SetTplHandle sets the handler of every new template, e.g. adding functions.
+// The cached templates are cleared, for they are built without the handler.
 func (this *Container) SetTplHandle(handler func(tpl *template.Template)) {
 
+	this.rwmu.Lock()
 	this.handler = handler
+	this.tpls.clear()
+	this.partials = make(map[string]*cached)
+	this.generation++
+	this.rwmu.Unlock()
+}
+
+// SetDefineMode sets whether the container combines pages in define mode,
+// see Combiner.SetDefineMode. In define mode the included files are parsed
+// once and shared by all pages of the same directory, and parse errors of
+// included files and sections report their template names.
+func (this *Container) SetDefineMode(define bool) {
+
+	this.rwmu.Lock()
+	this.define = define
+	this.rwmu.Unlock()
+}
+
+// SetBuffered sets whether Display executes the templates into a buffer
+// first, the buffer is copied to the writer only if the execution succeeded,
+// so a failed execution never writes partial html.
+func (this *Container) SetBuffered(buffered bool) {
+
+	this.rwmu.Lock()
+	this.buffered = buffered
+	this.rwmu.Unlock()
+}
+
+// SetErrorHandle sets the handler for rendering an error page. In buffered
+// mode, if Display got an error, the handler will be called to write the error
+// page to the writer, and Display still returns the error.
+func (this *Container) SetErrorHandle(handler func(w io.Writer, err error)) {
+
+	this.rwmu.Lock()
+	this.errorHandler = handler
+	this.rwmu.Unlock()
+}
+
+// Funcs returns the functions used by the combined templates, the container
+// adds them to every new template before calling the template handler.
+//
+// "dict" makes a map from key value pairs, it is used by "@include" with
+// object parameters, e.g. {{template "card" (dict "title" (.Item.Name))}}.
+func Funcs() template.FuncMap {
+	return template.FuncMap{
+		"dict": dict,
+	}
+}
+
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs) % 2 != 0 {
+		return nil, errors.New("dict: odd number of arguments")
+	}
+	m := make(map[string]interface{}, len(pairs) / 2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict: key %v is not a string", pairs[i])
+		}
+		m[key] = pairs[i + 1]
+	}
+	return m, nil
 }
 
-// Clear all cache
+// SetCacheSize limits the template cache, the least recently used templates
+// are removed when more than n templates are cached, or the view sources of
+// the cached templates are larger than size bytes, zero means no limit.
+func (this *Container) SetCacheSize(n, size int) {
+
+	this.tpls.setLimits(n, size)
+}
+
+// Stats returns the statistics of the template cache.
+func (this *Container) Stats() CacheStats {
+
+	return this.tpls.stats()
+}
+
+// Clear all cache, it is safe to be called while displaying, e.g. reloading
+// views on SIGHUP.
 func (this *Container) Clear() {
 
-	this.tpls = make(map[string]*template.Template, 15)
+	this.rwmu.Lock()
+	this.tpls.clear()
+	this.partials = make(map[string]*cached)
+	this.generation++
+	this.rwmu.Unlock()
 }
 
 type Page struct {
 	Dir  string
 	File string
 	Debug bool
+
+	// The theme directory overrides the view files of the Dir, e.g. the
+	// file, its layouts and included files are read from the theme first,
+	// and fall back to the Dir if the theme has no such file.
+	Theme string
+
+	// Whether the front matter of the view files is removed, it is only
+	// set by BuildSite.
+	frontMatter bool
 }
 
 func NewPage(dir, file string) Page {
@@ -77,6 +328,16 @@ func NewPage(dir, file string) Page {
 	}
 }
 
+// NewThemePage returns a page which reads the view files from the theme
+// directory first, and falls back to the dir.
+func NewThemePage(theme, dir, file string) Page {
+	return Page{
+		Dir: dir,
+		File: file,
+		Theme: theme,
+	}
+}
+
 // Debug page will be ignored form errors
 func NewDebugPage(dir, file string) Page {
 	return Page {
@@ -87,82 +348,551 @@ func NewDebugPage(dir, file string) Page {
 }
 
 func (this *Container) Combine(ps ...Page) (html []byte, err error) {
+	html, _, err = this.combineMarked(ps, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return stripMarks(html), nil
+}
+
+// combineMarked combines the pages like Combine, but the html is marked by
+// the combiners, files are the view files read by the combiners, and their
+// dependencies are added into deps if deps is not nil, their modification
+// times are added into times if times is not nil.
+func (this *Container) combineMarked(ps []Page, deps map[string][]string, times map[string]time.Time) (html []byte, files []string, err error) {
 
 	pNum := len(ps)
 	pages := make([][]byte, pNum)
+	// templates defined by "@include" of all pages, they are named by the
+	// paths of the included files, so the pages define the same name only if
+	// they include the same file.
+	defines := make(map[string][]byte)
 	for idx, s := range ps {
-		html, err = Combine(string(s.Dir), string(s.File), this.ext)
+		combiner := this.newCombiner(s.Dir, s.Theme, times != nil)
+		combiner.SetFrontMatter(s.frontMatter)
+		html, err = combiner.combine(s.File)
 		if err != nil {
 			return
 		}
 		pages[idx] = html
+		files = append(files, combiner.Files()...)
+		if deps != nil {
+			addDependencies(deps, combiner.Dependencies())
+		}
+		addModTimes(times, combiner.modTimes)
+		for name, define := range combiner.defines {
+			if _, ok := defines[name]; !ok {
+				defines[name] = define
+			}
+		}
 	}
 	if pNum > 1 {
-		html = MergeHtml(pages)
+		if html, err = mergePages(pages, ps); err != nil {
+			return
+		}
 	} else if pNum == 1 {
 		html = pages[0]
 	}
-
+	html = append(html, compileDefines(defines)...)
 	return
 }
 
+// mergePages merges the combined pages, the errors tell which pages are
+// malformed.
+func mergePages(pages [][]byte, ps []Page) ([]byte, error) {
+	html, err := MergeHtmlE(pages)
+	if mes, ok := err.(MergeErrors); ok {
+		for _, me := range mes {
+			me.Page = &ps[me.Index]
+		}
+	}
+	return html, err
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return bytes.NewBuffer(nil)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	// do not keep huge buffers
+	if buf.Cap() <= 1 << 20 {
+		bufferPool.Put(buf)
+	}
+}
+
 func (this *Container) Display(w io.Writer, data interface{}, ps ...Page) error {
+	this.rwmu.RLock()
+	buffered, errorHandler := this.buffered, this.errorHandler
+	this.rwmu.RUnlock()
+	if !buffered {
+		return this.display(w, data, ps)
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	err := this.display(buf, data, ps)
+	if err != nil {
+		if errorHandler != nil {
+			errorHandler(w, err)
+		}
+		return err
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// Render executes the pages into a pooled buffer and returns a copy of the
+// result, nothing is returned if the execution failed.
+func (this *Container) Render(data interface{}, ps ...Page) ([]byte, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	err := this.display(buf, data, ps)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+func (this *Container) display(w io.Writer, data interface{}, ps []Page) error {
+
+	name := this.cacheName(ps)
+	tpl, ok := this.tpls.get(name)
+	if !ok {
+		var err error
+		tpl, err = this.build(name, ps)
+		if err != nil {
+			return err
+		}
+	}
+	// never execute templates while holding the lock
+	return tpl.execute(w, data, ps)
+}
 
+// cacheName returns the name of the template of the pages.
+func (this *Container) cacheName(ps []Page) string {
 	buf := bytes.NewBuffer(nil)
 	for _, s := range ps {
 		buf.WriteString(s.Dir)
 		buf.WriteRune(filepath.Separator)
 		buf.WriteString(s.File)
+		// every theme has its own templates
+		if s.Theme != "" {
+			buf.WriteString("|")
+			buf.WriteString(s.Theme)
+		}
+		if s.frontMatter {
+			buf.WriteString("|---")
+		}
 	}
-	name := buf.String()
-	this.rwmu.RLock()
-	tpl, ok := this.tpls[name]
-	this.rwmu.RUnlock()
-	if ok {
-		return tpl.Execute(w, data)
+	return buf.String()
+}
+
+// building is a template being built, the concurrent displays of the same
+// pages wait for it instead of building the template again.
+type building struct {
+	wg  sync.WaitGroup
+	tpl *cached
+	err error
+}
+
+// build combines and parses the pages into the template of the name, and
+// caches it if the container is not in debug mode. Only one build of the
+// same name runs at a time.
+func (this *Container) build(name string, ps []Page) (*cached, error) {
+	this.rwmu.Lock()
+	if tpl, ok := this.tpls.peek(name); ok {
+		this.rwmu.Unlock()
+		return tpl, nil
+	}
+	if b, ok := this.building[name]; ok {
+		this.rwmu.Unlock()
+		b.wg.Wait()
+		return b.tpl, b.err
+	}
+	b := &building{}
+	b.wg.Add(1)
+	this.building[name] = b
+	watching, define, generation := this.watching, this.define, this.generation
+	this.rwmu.Unlock()
+
+	defer func() {
+		this.rwmu.Lock()
+		delete(this.building, name)
+		// the cache may be cleared or invalidated while building, the
+		// template may come from stale files then
+		if b.err == nil && !this.debug && this.generation == generation {
+			this.tpls.add(name, b.tpl)
+		}
+		this.rwmu.Unlock()
+		b.wg.Done()
+	}()
+
+	c, err := this.combine(ps, define, watching)
+	if err != nil {
+		b.err = err
+		return nil, err
+	}
+	this.rwmu.Lock()
+	this.setDependencies(c.files, c.deps)
+	this.rwmu.Unlock()
+	// the templates cached before watching have zero times, they will be
+	// rebuilt once the watcher starts
+	files := make(map[string]time.Time, len(c.files))
+	for _, file := range c.files {
+		files[file] = c.modTimes[file]
+	}
+	// parsing never holds the lock but for updating the shared partials
+	tpl, err := this.parse(name, c, ps, files, generation)
+	if err != nil {
+		b.err = err
+		return nil, err
+	}
+	tpl.files = files
+	tpl.deps = c.deps
+	for _, sm := range tpl.sourceMaps {
+		tpl.size += len(sm.content)
+	}
+	b.tpl = tpl
+	return tpl, nil
+}
+
+// cached is a parsed template with the source maps of all its associated
+// templates, keyed by template names.
+type cached struct {
+	tpl        *template.Template
+	sourceMaps map[string]*SourceMap
+
+	// The view files the template was built from, with their modification
+	// times recorded in watch mode.
+	files      map[string]time.Time
+
+	// The dependency graph of the files.
+	deps       map[string][]string
+
+	// The size of the view sources.
+	size       int
+}
+
+// changed reports whether any of the files has another modification time than
+// the one the template was built with, e.g. a shared partial set built before
+// the file changed.
+func (c *cached) changed(files map[string]time.Time) bool {
+	for file, modTime := range files {
+		if old, ok := c.files[file]; ok && !old.Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// execute executes the template, the execution error will be turned into
+// *ExecuteError.
+func (c *cached) execute(w io.Writer, data interface{}, ps []Page) error {
+	err := c.tpl.Execute(w, data)
+	if err != nil {
+		return c.executeError(err, ps)
+	}
+	return nil
+}
+
+// template: name:line:col: executing "name" at <.Foo>: message
+var executePatten = regexp.MustCompile(`^template: (.+?):(\d+):\d+: (?:executing ".*?" at <(.*?)>: )?([\s\S]*)$`)
+
+func (c *cached) executeError(err error, ps []Page) error {
+	ee := &ExecuteError{Pages: errorPages(ps), err: err}
+	var line int
+	var escapeErr *template.Error
+	if errors.As(err, &escapeErr) {
+		// error of escaping contexts, e.g. "{{.}}" in an unquoted attribute
+		ee.Template = escapeErr.Name
+		ee.Err = escapeErr.Description
+		line = escapeErr.Line
+	} else if strs := executePatten.FindStringSubmatch(err.Error()); strs != nil {
+		ee.Template = strs[1]
+		line, _ = strconv.Atoi(strs[2])
+		if strs[3] != "" {
+			ee.Action = "<" + strs[3] + ">"
+		}
+		ee.Err = strs[4]
 	} else {
-		html, err := this.Combine(ps...)
+		return err
+	}
+	if sm := c.sourceMaps[ee.Template]; sm != nil {
+		ee.Position = sm.LinePosition(line)
+		ee.Lines = sm.Lines(line, 2)
+	}
+	return ee
+}
+
+// combined holds the combined pages which are ready to be parsed.
+type combined struct {
+	html     []byte
+
+	// Whether the pages are combined in define mode.
+	define   bool
+
+	// The templates of sections in define mode.
+	sections map[string][]byte
+
+	// The templates of included files of every page in define mode.
+	includes []map[string][]byte
+
+	// The view files read by the combiners.
+	files    []string
+
+	// The dependency graph of the files.
+	deps     map[string][]string
+
+	// The modification times of the files taken before reading them, they
+	// are only recorded in watch mode.
+	modTimes map[string]time.Time
+}
+
+// combine combines the pages, in define mode if define is true. The
+// modification times of the files are recorded if watching is true.
+func (this *Container) combine(ps []Page, define, watching bool) (*combined, error) {
+	var times map[string]time.Time
+	if watching {
+		times = make(map[string]time.Time)
+	}
+	if !define {
+		deps := make(map[string][]string)
+		html, files, err := this.combineMarked(ps, deps, times)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		this.rwmu.Lock()
-		defer this.rwmu.Unlock()
-		tpl = template.New(name)
-		if this.handler != nil {
-			this.handler(tpl)
+		return &combined{html: html, files: files, deps: deps, modTimes: times}, nil
+	}
+	pNum := len(ps)
+	pages := make([][]byte, pNum)
+	c := &combined{
+		define: true,
+		sections: make(map[string][]byte),
+		includes: make([]map[string][]byte, pNum),
+		deps: make(map[string][]string),
+		modTimes: times,
+	}
+	for idx, s := range ps {
+		combiner := this.newCombiner(s.Dir, s.Theme, watching)
+		combiner.SetDefineMode(true)
+		combiner.SetFrontMatter(s.frontMatter)
+		html, err := combiner.combine(s.File)
+		if err != nil {
+			return nil, err
 		}
-		tpl, err := tpl.Parse(string(html))
+		pages[idx] = html
+		c.files = append(c.files, combiner.Files()...)
+		addDependencies(c.deps, combiner.Dependencies())
+		addModTimes(c.modTimes, combiner.modTimes)
+		for section, define := range combiner.sectionDefines {
+			c.sections[section] = define
+		}
+		c.includes[idx] = combiner.defines
+	}
+	if pNum > 1 {
+		var err error
+		if c.html, err = mergePages(pages, ps); err != nil {
+			return nil, err
+		}
+	} else if pNum == 1 {
+		c.html = pages[0]
+	}
+	return c, nil
+}
+
+// newTemplate returns a new template handled by the template handler, it must
+// not be called while holding the lock, for the handler may call the setters.
+func (this *Container) newTemplate(name string) *template.Template {
+	this.rwmu.RLock()
+	handler := this.handler
+	this.rwmu.RUnlock()
+	tpl := template.New(name).Funcs(Funcs())
+	if handler != nil {
+		handler(tpl)
+	}
+	return tpl
+}
+
+// parse parses the combined pages into a new template. In define mode the
+// included files are parsed once into the template set of the directory of
+// the first page, and the page template is built on a clone of the set. The
+// files are the view files of the pages with their modification times, and
+// the generation is the one the pages were combined in.
+func (this *Container) parse(name string, c *combined, ps []Page, files map[string]time.Time, generation int) (*cached, error) {
+	if !c.define || len(ps) == 0 {
+		tpl := &cached{sourceMaps: make(map[string]*SourceMap, 1)}
+		var err error
+		tpl.tpl, err = parseMarked(this.newTemplate(name), "", c.html, tpl.sourceMaps, ps)
 		if err != nil {
-			if line, e, ok := isParseError(err); ok {
-				scanner := bufio.NewScanner(bytes.NewReader(html))
-				for i := 0; i < line && scanner.Scan(); i++ {}
-				if err := scanner.Err(); err != nil {
-					return err
-				}
-				// Ignore debug page
-				var pgs []Page
-				for _, p := range ps {
-					if !p.Debug {
-						pgs = append(pgs, p)
-					}
-				}
-				return &ParseError {
-					Line: scanner.Text(),
-					Err: e,
-					Pages: pgs,
+			return nil, err
+		}
+		return tpl, nil
+	}
+	tpl, err := this.partialSet(ps[0], c.includes[0], ps, files, generation)
+	if err != nil {
+		return nil, err
+	}
+	// the included files of other directories only belong to this template,
+	// the templates are named by the file paths, so a page never executes
+	// the partial of another page.
+	for _, defines := range c.includes[1:] {
+		for define, content := range defines {
+			if tpl.tpl.Lookup(define) == nil {
+				if _, err := parseMarked(tpl.tpl.New(define), define, content, tpl.sourceMaps, ps); err != nil {
+					return nil, err
 				}
 			}
-			return err
 		}
-		err = tpl.Execute(w, data)
+	}
+	for section, content := range c.sections {
+		if _, err := parseMarked(tpl.tpl.New(section), section, content, tpl.sourceMaps, ps); err != nil {
+			return nil, err
+		}
+	}
+	tpl.tpl, err = parseMarked(tpl.tpl.New(name), "", c.html, tpl.sourceMaps, ps)
+	if err != nil {
+		return nil, err
+	}
+	return tpl, nil
+}
+
+// partialSet returns a clone of the template set shared by the pages of the
+// directory and theme of the page, the included files which are not yet in
+// the set will be parsed into it. The templates of the set are keyed by the
+// paths of the included files, see Combiner.SetDefineMode. The set itself is
+// never executed, so it can always be cloned. The set is not shared in debug
+// mode, or if the cache has been cleared or invalidated since the generation.
+//
+// The shared sets are never changed, the new partials are parsed into a copy
+// of the set without holding the lock, and the copy replaces the set. The
+// set records the files of the pages which parsed templates into it, so it
+// is removed whenever any of them changes, whether the templates of the
+// pages are still cached or not.
+func (this *Container) partialSet(p Page, includes map[string][]byte, ps []Page, files map[string]time.Time, generation int) (*cached, error) {
+	dir := p.Dir
+	if p.Theme != "" {
+		dir += "|" + p.Theme
+	}
+	this.rwmu.RLock()
+	old := this.partials[dir]
+	shared := !this.debug && this.generation == generation
+	this.rwmu.RUnlock()
+	set := old
+	if set == nil || !shared || set.changed(files) {
+		set = nil
+	}
+	var missing []string
+	for define := range includes {
+		if set == nil || set.tpl.Lookup(define) == nil {
+			missing = append(missing, define)
+		}
+	}
+	if set == nil || len(missing) > 0 {
+		next, err := this.copySet(set, dir)
 		if err != nil {
+			return nil, err
+		}
+		for _, define := range missing {
+			if _, err := parseMarked(next.tpl.New(define), define, includes[define], next.sourceMaps, ps); err != nil {
+				return nil, err
+			}
+		}
+		for file, modTime := range files {
+			if _, ok := next.files[file]; !ok {
+				next.files[file] = modTime
+			}
+		}
+		this.rwmu.Lock()
+		// another page may have replaced the set meanwhile, its partials
+		// are kept then
+		if !this.debug && this.generation == generation && this.partials[dir] == old {
+			this.partials[dir] = next
+		}
+		this.rwmu.Unlock()
+		set = next
+	}
+	return this.copySet(set, dir)
+}
+
+// copySet returns a copy of the template set which can be changed, or a new
+// set of the dir if set is nil.
+func (this *Container) copySet(set *cached, dir string) (*cached, error) {
+	if set == nil {
+		return &cached{
+			tpl: this.newTemplate(dir),
+			sourceMaps: make(map[string]*SourceMap),
+			files: make(map[string]time.Time),
+		}, nil
+	}
+	clone, err := set.tpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	c := &cached{
+		tpl: clone,
+		sourceMaps: make(map[string]*SourceMap, len(set.sourceMaps)),
+		files: make(map[string]time.Time, len(set.files)),
+	}
+	for define, sm := range set.sourceMaps {
+		c.sourceMaps[define] = sm
+	}
+	for file, modTime := range set.files {
+		c.files[file] = modTime
+	}
+	return c, nil
+}
+
+// parseMarked removes the marks of the content and parses it into the
+// template, the source map of the content is saved into sms by the template
+// name. The parse error will be turned into *ParseError, the name is the
+// template name of the content in define mode.
+func parseMarked(tpl *template.Template, name string, content []byte, sms map[string]*SourceMap, ps []Page) (*template.Template, error) {
+	content, sm := unmark(content)
+	t, err := tpl.Parse(string(content))
+	if err != nil {
+		return nil, parseError(err, name, content, sm, ps)
+	}
+	sms[tpl.Name()] = sm
+	return t, nil
+}
+
+// parseError turns the parse error of the template content into *ParseError.
+// The name is the template name of the content in define mode.
+func parseError(err error, name string, content []byte, sm *SourceMap, ps []Page) error {
+	if line, e, ok := isParseError(err); ok {
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for i := 0; i < line && scanner.Scan(); i++ {}
+		if err := scanner.Err(); err != nil {
 			return err
 		}
-		if !this.debug {
-			this.tpls[name] = tpl
+		return &ParseError {
+			Template: name,
+			Line: scanner.Text(),
+			Position: sm.LinePosition(line),
+			Err: e,
+			Pages: errorPages(ps),
 		}
 	}
-	return nil
+	return err
+}
+
+// errorPages returns the pages which may cause errors.
+func errorPages(ps []Page) []Page {
+	// Ignore debug page
+	var pgs []Page
+	for _, p := range ps {
+		if !p.Debug {
+			pgs = append(pgs, p)
+		}
+	}
+	return pgs
 }
 
 var patten = regexp.MustCompile(`:(\d+):`)
@@ -0,0 +1,656 @@
+package view_test
+
+import (
+	"testing"
+	"bytes"
+	"path/filepath"
+	"strings"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"time"
+	"reflect"
+	"html/template"
+	"sync"
+	"sync/atomic"
+	"testing/fstest"
+	"gopkg.in/orivil/view.v0"
+)
+
+func TestContainerDefineMode(t *testing.T) {
+	container := view.NewContainer(false, fileExt)
+	container.SetDefineMode(true)
+	data := map[string]interface{}{
+		"Items": []map[string]interface{}{{"Name": "apple", "Price": 1.5}},
+		"Badge": "new",
+	}
+	expect := "<ul>\n    <li>apple: 1.50</li>\n</ul>\n<b>new</b>"
+	// the second display uses the cached template
+	for i := 0; i < 2; i++ {
+		buf := bytes.NewBuffer(nil)
+		err := container.Display(buf, data, view.NewPage("./testdata/components", "index"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != expect {
+			t.Error("got:", buf.String(), "expect:", expect)
+		}
+	}
+}
+
+func TestContainerDefineModeParseError(t *testing.T) {
+	container := view.NewContainer(false, fileExt)
+	container.SetDefineMode(true)
+	err := container.Display(bytes.NewBuffer(nil), nil, view.NewPage("./testdata/broken", "index"))
+	pe, ok := err.(*view.ParseError)
+	if !ok {
+		t.Fatalf("got error: %v, expect *view.ParseError", err)
+	}
+	if pe.Template != "testdata/broken/partials/broken" {
+		t.Error("got template:", pe.Template, "expect template:", "testdata/broken/partials/broken")
+	}
+}
+
+func TestContainerIncludeSameName(t *testing.T) {
+	for _, define := range []bool{false, true} {
+		container := view.NewContainer(false, fileExt)
+		container.SetDefineMode(define)
+		html, err := container.Render(nil, view.NewPage("./testdata/pages/a", "index"), view.NewPage("./testdata/pages/b", "index"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// every page executes its own partial
+		for _, nav := range []string{"<nav>NAV-A</nav>", "<nav>NAV-B</nav>"} {
+			if bytes.Count(html, []byte(nav)) != 1 {
+				t.Errorf("define mode %v got:\n%s\nexpect one %s", define, html, nav)
+			}
+		}
+	}
+}
+
+func TestContainerSetTplHandle(t *testing.T) {
+	views := fstest.MapFS{
+		"a.blade.php": {Data: []byte(`@include("nav")a`)},
+		"b.blade.php": {Data: []byte(`@include("nav"){{up "b"}}`)},
+		"nav.blade.php": {Data: []byte(`nav `)},
+	}
+	for _, define := range []bool{false, true} {
+		container := view.NewFSContainer(views, false, fileExt)
+		container.SetDefineMode(define)
+		if _, err := container.Render(nil, view.NewPage(".", "a")); err != nil {
+			t.Fatal(err)
+		}
+		// the partials parsed without the handler are not reused
+		container.SetTplHandle(func(tpl *template.Template) {
+			tpl.Funcs(template.FuncMap{"up": strings.ToUpper})
+		})
+		html, err := container.Render(nil, view.NewPage(".", "b"))
+		if err != nil {
+			t.Fatalf("define mode %v got error: %v", define, err)
+		}
+		if string(html) != "nav B" {
+			t.Errorf("define mode %v got: %q, expect: %q", define, html, "nav B")
+		}
+	}
+}
+
+type user struct {
+	Name string
+}
+
+func TestExecuteError(t *testing.T) {
+	data := struct {
+		Title string
+		User  *user
+	}{Title: "title"}
+	for _, define := range []bool{false, true} {
+		container := view.NewContainer(false, fileExt)
+		container.SetDefineMode(define)
+		// the second display uses the cached template
+		for i := 0; i < 2; i++ {
+			err := container.Display(bytes.NewBuffer(nil), data, view.NewPage("./testdata/broken", "exec"))
+			ee, ok := err.(*view.ExecuteError)
+			if !ok {
+				t.Fatalf("got error: %v, expect *view.ExecuteError", err)
+			}
+			expect := view.Position{File: filepath.Join("testdata/broken/exec" + fileExt), Line: 4}
+			if ee.Position != expect {
+				t.Error("got position:", ee.Position, "expect position:", expect)
+			}
+			if ee.Action != "<.User.Name>" {
+				t.Error("got action:", ee.Action, "expect action:", "<.User.Name>")
+			}
+			var found bool
+			for _, l := range ee.Lines {
+				found = found || l.Position == expect && strings.Contains(l.Text, "{{.User.Name}}")
+			}
+			if !found {
+				t.Error("got lines:", ee.Lines, "expect the line of the action")
+			}
+		}
+	}
+}
+
+func TestExecuteErrorInHead(t *testing.T) {
+	views := fstest.MapFS{
+		"one.blade.php": {Data: []byte("<html>\n<head>\n    <title>one</title>\n    <meta name=\"a\" content=\"a\">\n    <meta name=\"b\" content=\"b\">\n</head>\n<body>one</body>\n</html>")},
+		"two.blade.php": {Data: []byte("<html>\n<head>\n    <title>two</title>\n    <meta name=\"c\" content=\"{{.User.Name}}\">\n</head>\n<body>two</body>\n</html>")},
+	}
+	data := struct {
+		User *user
+	}{}
+	for _, define := range []bool{false, true} {
+		container := view.NewFSContainer(views, false, fileExt)
+		container.SetDefineMode(define)
+		_, err := container.Render(data, view.NewPage(".", "one"), view.NewPage(".", "two"))
+		ee, ok := err.(*view.ExecuteError)
+		if !ok {
+			t.Fatalf("got error: %v, expect *view.ExecuteError", err)
+		}
+		// the merged head tags keep their positions
+		expect := view.Position{File: "two" + fileExt, Line: 4}
+		if ee.Position != expect {
+			t.Errorf("define mode %v got position: %v, expect position: %v", define, ee.Position, expect)
+		}
+	}
+}
+
+func TestBufferedDisplay(t *testing.T) {
+	data := struct {
+		Title string
+		User  *user
+	}{Title: "title"}
+	container := view.NewContainer(false, fileExt)
+	container.SetBuffered(true)
+	buf := bytes.NewBuffer(nil)
+	err := container.Display(buf, data, view.NewPage("./testdata/broken", "exec"))
+	if _, ok := err.(*view.ExecuteError); !ok {
+		t.Fatalf("got error: %v, expect *view.ExecuteError", err)
+	}
+	if buf.Len() != 0 {
+		t.Error("got partial html:", buf.String())
+	}
+
+	container.SetErrorHandle(func(w io.Writer, err error) {
+		io.WriteString(w, "500")
+	})
+	err = container.Display(buf, data, view.NewPage("./testdata/broken", "exec"))
+	if err == nil || buf.String() != "500" {
+		t.Error("got error:", err, "got html:", buf.String(), "expect html: 500")
+	}
+}
+
+func TestRender(t *testing.T) {
+	container := view.NewContainer(false, fileExt)
+	html, err := container.Render("hello", view.NewPage("./testdata", "functest"))
+	if html != nil || err == nil {
+		t.Error("got html:", string(html), "got error:", err, "expect an error")
+	}
+	html, err = container.Render(28, view.NewPage("./testdata", "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(html), "28") {
+		t.Error("got html:", string(html), "expect data: 28")
+	}
+}
+
+func TestContainerWatch(t *testing.T) {
+	dir := t.TempDir()
+	write := func(file, content string, modTime time.Time) {
+		filename := filepath.Join(dir, file + fileExt)
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filename, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	then := time.Now().Add(-time.Hour)
+	write("index", `@include("nav")index`, then)
+	write("nav", "nav1 ", then)
+	write("other", "other1", then)
+	container := view.NewContainer(false, fileExt)
+	stop := container.Watch(5 * time.Millisecond)
+	defer stop()
+	render := func(file string) string {
+		html, err := container.Render(nil, view.NewPage(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(html)
+	}
+	if html := render("index"); html != "nav1 index" {
+		t.Fatal("got html:", html)
+	}
+	if html := render("other"); html != "other1" {
+		t.Fatal("got html:", html)
+	}
+	// the content of "other" changes without its modification time, so
+	// it stays cached
+	write("other", "other2", then)
+	write("nav", "nav2 ", time.Now())
+	deadline := time.Now().Add(2 * time.Second)
+	for render("index") != "nav2 index" {
+		if time.Now().After(deadline) {
+			t.Fatal("the changed include is not reloaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if html := render("other"); html != "other1" {
+		t.Error("got html:", html, "expect the cached html: other1")
+	}
+}
+
+// savingFS saves a new version of the file right after it is read.
+type savingFS struct {
+	mu    sync.Mutex
+	files fstest.MapFS
+	file  string
+	saved bool
+}
+
+func (s *savingFS) Open(name string) (fs.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.files.Open(name)
+}
+
+func (s *savingFS) ReadFile(name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	content, err := s.files.ReadFile(name)
+	if name == s.file && !s.saved {
+		s.saved = true
+		s.files[name] = &fstest.MapFile{Data: []byte("new"), ModTime: time.Now()}
+	}
+	return content, err
+}
+
+func TestContainerWatchSavedWhileReading(t *testing.T) {
+	fsys := &savingFS{
+		files: fstest.MapFS{
+			"index.blade.php": {Data: []byte("old"), ModTime: time.Now().Add(-time.Hour)},
+		},
+		file: "index.blade.php",
+	}
+	container := view.NewFSContainer(fsys, false, fileExt)
+	stop := container.Watch(5 * time.Millisecond)
+	defer stop()
+	// the template is cached with the time before reading, so the watcher
+	// finds the new version
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		html, err := container.Render(nil, view.NewPage(".", "index"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(html) == "new" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got html: %q, expect the new version", html)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestContainerInvalidate(t *testing.T) {
+	container := view.NewContainer(false, fileExt)
+	pages := [][]view.Page{
+		{view.NewPage("./testdata/include", "index")},
+		{view.NewPage("./testdata/nested", "index")},
+		{view.NewPage("./testdata", "index")},
+	}
+	for _, ps := range pages {
+		if _, err := container.Render("a", ps...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	item := filepath.Join("testdata", "include", "partials", "item" + fileExt)
+	expect := []string{
+		filepath.Join("testdata", "include", "index" + fileExt),
+		filepath.Join("testdata", "include", "partials", "nav" + fileExt),
+	}
+	if got := container.Dependents("./" + item); !reflect.DeepEqual(got, expect) {
+		t.Errorf("got dependents: %v\nexpect: %v", got, expect)
+	}
+	if n := container.Invalidate(item); n != 1 {
+		t.Errorf("got %d invalidated templates, expect 1", n)
+	}
+	// the dependency graph is kept without the cached templates
+	abs, err := filepath.Abs(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := container.Dependents(abs); !reflect.DeepEqual(got, expect) {
+		t.Errorf("got dependents: %v\nexpect: %v", got, expect)
+	}
+	base, err := filepath.Abs(filepath.Join("testdata", "nested", "layouts", "base" + fileExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := container.Invalidate(base); n != 1 {
+		t.Errorf("got %d invalidated templates, expect 1", n)
+	}
+
+	// debug mode caches nothing
+	container = view.NewContainer(true, fileExt)
+	if _, err := container.Render("a", pages[0]...); err != nil {
+		t.Fatal(err)
+	}
+	if got := container.Dependents(item); !reflect.DeepEqual(got, expect) {
+		t.Errorf("got dependents in debug mode: %v\nexpect: %v", got, expect)
+	}
+}
+
+func TestContainerInvalidateEvictedPartial(t *testing.T) {
+	dir := t.TempDir()
+	write := func(file, content string, modTime time.Time) {
+		filename := filepath.Join(dir, file + fileExt)
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filename, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	then := time.Now().Add(-time.Hour)
+	for _, watch := range []bool{false, true} {
+		write("index", `@include("nav")index`, then)
+		write("nav", "OLD ", then)
+		write("plain", "plain", then)
+		container := view.NewContainer(false, fileExt)
+		container.SetDefineMode(true)
+		container.SetCacheSize(1, 0)
+		if watch {
+			stop := container.Watch(5 * time.Millisecond)
+			defer stop()
+		}
+		render := func(file string) string {
+			html, err := container.Render(nil, view.NewPage(dir, file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return string(html)
+		}
+		render("index")
+		// evicts the template of index
+		render("plain")
+		write("nav", "NEW ", time.Now())
+		if !watch {
+			if n := container.Invalidate(filepath.Join(dir, "nav" + fileExt)); n != 0 {
+				t.Errorf("got %d invalidated templates, expect 0", n)
+			}
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for render("index") != "NEW index" {
+			if time.Now().After(deadline) {
+				t.Fatalf("watch %v: the shared partial is not reloaded", watch)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
+
+// run with -race
+func TestContainerConcurrentConfig(t *testing.T) {
+	container := view.NewContainer(false, fileExt)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			container.Clear()
+			container.SetTplHandle(func(tpl *template.Template) {})
+			container.SetDefineMode(i % 2 == 0)
+			container.SetBuffered(i % 2 == 1)
+			container.SetErrorHandle(func(w io.Writer, err error) {})
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		err := container.Display(ioutil.Discard, "a", view.NewPage("./testdata/include", "index"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestContainerSingleBuild(t *testing.T) {
+	container := view.NewContainer(false, fileExt)
+	var builds int32
+	container.SetTplHandle(func(tpl *template.Template) {
+		atomic.AddInt32(&builds, 1)
+	})
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			err := container.Display(ioutil.Discard, "a", view.NewPage("./testdata/nested", "index"))
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if builds := atomic.LoadInt32(&builds); builds != 1 {
+		t.Errorf("the page is built %d times, expect 1", builds)
+	}
+}
+
+func TestContainerExecuteWithoutLock(t *testing.T) {
+	views := fstest.MapFS{
+		"slow.blade.php": {Data: []byte(`{{wait}}slow`)},
+		"fast.blade.php": {Data: []byte(`fast`)},
+	}
+	container := view.NewFSContainer(views, false, fileExt)
+	entered, release := make(chan struct{}), make(chan struct{})
+	container.SetTplHandle(func(tpl *template.Template) {
+		tpl.Funcs(template.FuncMap{"wait": func() string {
+			close(entered)
+			<-release
+			return ""
+		}})
+	})
+	done := make(chan error)
+	go func() {
+		done <- container.Display(ioutil.Discard, nil, view.NewPage(".", "slow"))
+	}()
+	<-entered
+	fast := make(chan error)
+	go func() {
+		_, err := container.Render(nil, view.NewPage(".", "fast"))
+		fast <- err
+	}()
+	select {
+	case err := <-fast:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("displaying is blocked by the execution of another page")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestContainerParseWithoutLock(t *testing.T) {
+	views := fstest.MapFS{
+		"fast.blade.php": {Data: []byte(`@include("nav")fast`)},
+		"nav.blade.php": {Data: []byte(`nav `)},
+		"admin/slow.blade.php": {Data: []byte(`@include("nav")slow`)},
+		"admin/nav.blade.php": {Data: []byte(`nav `)},
+	}
+	for _, define := range []bool{false, true} {
+		container := view.NewFSContainer(views, false, fileExt)
+		container.SetDefineMode(define)
+		entered, release := make(chan struct{}), make(chan struct{})
+		var once sync.Once
+		container.SetTplHandle(func(tpl *template.Template) {
+			// the handler may call the setters
+			container.SetBuffered(false)
+			if !strings.HasPrefix(tpl.Name(), "admin") {
+				return
+			}
+			once.Do(func() {
+				close(entered)
+			})
+			<-release
+		})
+		if _, err := container.Render(nil, view.NewPage(".", "fast")); err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error)
+		go func() {
+			_, err := container.Render(nil, view.NewPage("admin", "slow"))
+			done <- err
+		}()
+		select {
+		case <-entered:
+		case err := <-done:
+			t.Fatal("the handler is not called:", err)
+		}
+		fast := make(chan error)
+		go func() {
+			fast <- container.Display(ioutil.Discard, nil, view.NewPage(".", "fast"))
+		}()
+		select {
+		case err := <-fast:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Error("displaying is blocked by the parsing of another page")
+		}
+		close(release)
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestContainerCacheSize(t *testing.T) {
+	container := view.NewContainer(false, fileExt)
+	container.SetCacheSize(2, 0)
+	render := func(dir string) {
+		if _, err := container.Render("a", view.NewPage(dir, "index")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	render("./testdata/include")
+	render("./testdata/nested")
+	render("./testdata/include")
+	// evicts the least recently used "nested"
+	render("./testdata")
+	render("./testdata/include")
+	render("./testdata/nested")
+	stats := container.Stats()
+	expect := view.CacheStats{Hits: 2, Misses: 4, Evictions: 2, Templates: 2, Bytes: stats.Bytes}
+	if stats != expect || stats.Bytes <= 0 {
+		t.Errorf("got stats: %+v\nexpect: %+v", stats, expect)
+	}
+	// the newest template is always kept
+	container.SetCacheSize(0, 1)
+	if stats := container.Stats(); stats.Templates != 1 || stats.Evictions != 3 {
+		t.Errorf("got stats: %+v", stats)
+	}
+}
+
+func TestContainerPrecompileDir(t *testing.T) {
+	container := view.NewContainer(false, fileExt)
+	files, err := container.ViewFiles("./testdata/nested")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"index", "layouts/base", "layouts/shop"}; !reflect.DeepEqual(files, expect) {
+		t.Errorf("got files: %v, expect: %v", files, expect)
+	}
+	if err := container.PrecompileDir("./testdata/nested"); err != nil {
+		t.Fatal(err)
+	}
+	// the layouts are not pages
+	if stats := container.Stats(); stats.Templates != 1 {
+		t.Errorf("got %d cached templates, expect 1", stats.Templates)
+	}
+	// the partial uses the variable of the page
+	if err := container.PrecompileDir("./testdata/loop"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = container.PrecompileDir("./testdata/broken")
+	pe, ok := err.(*view.PrecompileError)
+	if !ok || len(pe.Errors) != 2 {
+		t.Fatal("got error:", err, "expect 2 errors of func and index")
+	}
+	for _, err := range pe.Errors {
+		if _, ok := err.(*view.ParseError); !ok {
+			t.Errorf("got error: %v, expect *view.ParseError", err)
+		}
+	}
+}
+
+func TestContainerThemeDefineMode(t *testing.T) {
+	container := view.NewContainer(false, fileExt)
+	container.SetDefineMode(true)
+	// the themes do not share the included files
+	for theme, footer := range map[string]string{"": "base", "./testdata/themes/acme": "acme"} {
+		html, err := container.Render("home", view.NewThemePage(theme, "./testdata/themes/base", "index"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(html), "<footer>" + footer + "</footer>") {
+			t.Errorf("got html of theme %q:\n%s", theme, html)
+		}
+	}
+}
+
+func TestContainerAddNamespace(t *testing.T) {
+	container := view.NewContainer(false, fileExt)
+	container.AddNamespace("admin", "./testdata/namespaces/admin")
+	container.AddNamespace("shared", "./testdata/namespaces/shared")
+	container.AddNamespace("blog", "./testdata/namespaces/vendor/blog")
+	render := func(file, nav string) {
+		html, err := container.Render("users", view.NewPage("", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(html), nav) {
+			t.Errorf("got html of %s:\n%s\nexpect: %s", file, html, nav)
+		}
+	}
+	render("admin::users.index", "<nav>shared</nav>")
+	render("blog::index", "<nav>vendor</nav>")
+	// the directories added later take no precedence
+	container.AddNamespace("shared", "./testdata/namespaces/app/shared")
+	if stats := container.Stats(); stats.Templates != 0 {
+		t.Errorf("got %d cached templates, expect 0", stats.Templates)
+	}
+	render("admin::users.index", "<nav>shared</nav>")
+	// the application overrides the views of the namespaces, the relative
+	// names of the namespaced files are overridden too
+	container.PrependNamespace("shared", "./testdata/namespaces/app/shared")
+	container.PrependNamespace("blog", "./testdata/namespaces/app/blog")
+	render("admin::users.index", "<nav>app</nav>")
+	render("blog::index", "<nav>app</nav>")
+}
+
+func TestContainerMergeError(t *testing.T) {
+	container := view.NewContainer(false, fileExt)
+	// the included list has no <body>
+	_, err := container.Combine(view.NewPage("./testdata/nested", "index"), view.NewPage("./testdata/include", "index"))
+	errs, ok := err.(view.MergeErrors)
+	if !ok || len(errs) != 1 || errs[0].Page == nil || errs[0].Page.Dir != "./testdata/include" {
+		t.Fatal("got error:", err, "expect the merge error of ./testdata/include")
+	}
+}
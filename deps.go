@@ -0,0 +1,123 @@
+package view
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// addDependencies adds the dependency graph src into dst.
+func addDependencies(dst, src map[string][]string) {
+	for file, deps := range src {
+	next:
+		for _, dep := range deps {
+			for _, d := range dst[file] {
+				if d == dep {
+					continue next
+				}
+			}
+			dst[file] = append(dst[file], dep)
+		}
+	}
+}
+
+// setDependencies records the dependencies of the files read by a build into
+// the dependency graph of the container, the old dependencies of the files
+// are replaced. The caller must hold the write lock.
+func (this *Container) setDependencies(files []string, deps map[string][]string) {
+	for _, file := range files {
+		if d, ok := deps[file]; ok {
+			this.deps[file] = d
+		} else {
+			delete(this.deps, file)
+		}
+	}
+}
+
+// Dependents returns the paths of the view files which extend or include the
+// file directly or indirectly, e.g. the pages using a partial, according to
+// the dependency graph of all templates built by the container, whether they
+// are cached or not. The file is the path of a view file, relative or
+// absolute, e.g. "views/partials/nav.blade.php". The returned paths are in
+// the form the pages were built with.
+func (this *Container) Dependents(file string) []string {
+	file = this.absPath(file)
+	// the reversed graph, file -> files which depend on it
+	graph := make(map[string][]string)
+	this.rwmu.RLock()
+	for f, deps := range this.deps {
+		for _, dep := range deps {
+			dep = this.absPath(dep)
+			graph[dep] = append(graph[dep], f)
+		}
+	}
+	this.rwmu.RUnlock()
+
+	seen := map[string]bool{file: true}
+	var dependents []string
+	queue := []string{file}
+	for len(queue) > 0 {
+		f := queue[0]
+		queue = queue[1:]
+		for _, d := range graph[f] {
+			abs := this.absPath(d)
+			if !seen[abs] {
+				seen[abs] = true
+				dependents = append(dependents, d)
+				queue = append(queue, abs)
+			}
+		}
+	}
+	sort.Strings(dependents)
+	return dependents
+}
+
+// Invalidate removes the cached templates built from the view file, e.g. the
+// pages which extend or include the updated file, the other templates stay
+// cached. The file is the path of a view file, relative or absolute. Returns
+// the number of the removed templates.
+func (this *Container) Invalidate(file string) int {
+	file = this.absPath(file)
+	this.rwmu.Lock()
+	defer this.rwmu.Unlock()
+	return this.invalidate(map[string]bool{file: true})
+}
+
+// invalidate removes the cached templates built from any of the files, the
+// files are absolute paths, see absPath. The caller must hold the write lock.
+func (this *Container) invalidate(files map[string]bool) int {
+	removed := this.tpls.removeIf(func(tpl *cached) bool {
+		for file := range tpl.files {
+			if files[this.absPath(file)] {
+				return true
+			}
+		}
+		return false
+	})
+	// the shared partials may come from the files, even if the templates
+	// using them have been evicted
+	for dir, set := range this.partials {
+		for file := range set.files {
+			if files[this.absPath(file)] {
+				delete(this.partials, dir)
+				break
+			}
+		}
+	}
+	// the templates being built may come from the old files
+	this.generation++
+	return removed
+}
+
+// absPath returns the absolute path of the view file, so the relative and
+// absolute paths of the same file match. The paths in the fs.FS of the
+// container are only cleaned.
+func (this *Container) absPath(file string) string {
+	if this.fsys != nil {
+		return path.Clean(file)
+	}
+	if abs, err := filepath.Abs(file); err == nil {
+		return abs
+	}
+	return filepath.Clean(file)
+}
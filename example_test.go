@@ -7,6 +7,7 @@ import (
 	"html/template"
 	"bytes"
 	"io/ioutil"
+	"testing/fstest"
 )
 
 
@@ -129,4 +130,156 @@ func ExampleMergeHtml() {
 	fmt.Println(bytes.Equal(buffer.Bytes(), result))
 	// Output:
 	// true
-}
\ No newline at end of file
+}
+func ExampleCombiner_multiLevel() {
+	// "index" extends "layouts/shop", and "layouts/shop" extends "layouts/base"
+	combiner := view.NewCombiner("./testdata/nested", fileExt)
+
+	content, err := combiner.Combine("index")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(string(content))
+
+	// Output:
+	// <html>
+	// <title>Cart</title>
+	// <body>
+	//     <shop>
+	//         <cart>{{.}}</cart>
+	//     </shop>
+	// </body>
+	// </html>
+}
+
+func ExampleCombiner_parent() {
+	// the layout provides default sections by "@section ... @show", and the
+	// child page splices them by "@parent"
+	combiner := view.NewCombiner("./testdata/parent", fileExt)
+
+	content, err := combiner.Combine("index")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(string(content))
+
+	// Output:
+	// <head>
+	//     <script src="/app.js"></script>
+	//     <script src="/page.js"></script>
+	// </head>
+	// <nav><a>home</a></nav>
+}
+
+func ExampleCombiner_yieldDefault() {
+	// the layout gives default title by "@yield("title", "Default Title")",
+	// and default sidebar by "@sectionMissing("sidebar") ... @endif"
+	combiner := view.NewCombiner("./testdata/defaults", fileExt)
+
+	// combine the layout standalone
+	layout, err := combiner.Combine("layout")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(layout))
+
+	// combine the page which only covers the sidebar
+	page, err := combiner.Combine("index")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(page))
+
+	// Output:
+	// <title>Default Title</title>
+	// <aside><nav>default</nav></aside>
+	// <title>Default Title</title>
+	// <aside><nav>index</nav></aside>
+}
+
+func ExampleContainer_includeParameters() {
+	container := view.NewContainer(true, fileExt)
+
+	// "@include("partials/card", {"title": .Name, ...})" executes the partial
+	// with its own data
+	data := map[string]interface{}{
+		"Items": []map[string]interface{}{
+			{"Name": "apple", "Price": 1.5},
+			{"Name": "pear", "Price": 2.0},
+		},
+		"Badge": "new",
+	}
+	err := container.Display(os.Stdout, data, view.NewPage("./testdata/components", "index"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Output:
+	// <ul>
+	//     <li>apple: 1.50</li>
+	//     <li>pear: 2.00</li>
+	// </ul>
+	// <b>new</b>
+}
+
+func ExampleNewFSContainer() {
+	// views can also be read from an embed.FS
+	views := fstest.MapFS{
+		"views/layout.blade.php": {Data: []byte(`<title>@yield("title")</title>`)},
+		"views/index.blade.php": {Data: []byte(`@extends("layout")
+@section("title"){{.}}@endsection`)},
+	}
+	container := view.NewFSContainer(views, false, fileExt)
+
+	err := container.Display(os.Stdout, "hello world!", view.NewPage("views", "index"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Output:
+	// <title>hello world!</title>
+}
+
+func ExampleContainer_AddNamespace() {
+	container := view.NewContainer(false, fileExt)
+	container.AddNamespace("admin", "./testdata/namespaces/admin")
+	// the application overrides the shared navigation
+	container.AddNamespace("shared", "./testdata/namespaces/app/shared", "./testdata/namespaces/shared")
+
+	err := container.Display(os.Stdout, "users", view.NewPage("", "admin::users.index"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Output:
+	// <html>
+	// <body>
+	//     <nav>app</nav>
+	//     <p>users</p>
+	// </body>
+	// </html>
+}
+
+func ExampleNewThemePage() {
+	container := view.NewContainer(false, fileExt)
+	// the "acme" theme overrides the layout and the footer
+	for _, theme := range []string{"", "./testdata/themes/acme"} {
+		err := container.Display(os.Stdout, "home", view.NewThemePage(theme, "./testdata/themes/base", "index"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println()
+	}
+
+	// Output:
+	// <html>
+	// <title>home</title>
+	// <body><footer>base</footer></body>
+	// </html>
+	// <html class="acme">
+	// <title>Acme - home</title>
+	// <body><footer>acme</footer></body>
+	// </html>
+}
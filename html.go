@@ -2,16 +2,45 @@ package view
 
 import (
 	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
 	"unicode"
+	"golang.org/x/net/html"
 	"gopkg.in/orivil/sorter.v0"
 )
 
 type Tag struct {
 	Name       string
 	HasContent bool
+
+	// The attributes written into the merged tag, if the tag has no Key.
 	Attr       []string
+
+	// The attributes which identify the tag, e.g. "src" of <script>, a key
+	// may have multiple space-separated attributes, e.g. "rel href" of
+	// <link>, so a preload link and the stylesheet link of the same href
+	// are different tags. The first key the tag has all attributes of is
+	// used, the tags of the same key are merged into one by the
+	// HeadTagPolicy. If the Key is set, the tag is written
+	// verbatim with all its attributes, e.g. "integrity" and "nonce", and the
+	// tags which have none of the Key attributes are merged by their whole
+	// text. An empty but non-nil Key merges all the tags by their whole text,
+	// e.g. <style>.
+	Key        []string
+
+	// The attributes or the attribute values which allow only one tag in
+	// the merged head whatever the other attributes are, e.g. "charset" of
+	// <meta> and "rel=canonical" of <link>. They are checked before the Key,
+	// the values are compared case-insensitively.
+	Singleton  []string
 }
 
+// guards the head tags and the merging policies, they may be set while
+// displaying
+var policyMu sync.RWMutex
+
 // default head tags
 var headTags = []Tag{
 	Tag{
@@ -23,6 +52,16 @@ var headTags = []Tag{
 			"content",
 			"charset",
 		},
+		Key: []string{
+			"charset",
+			"name",
+			"property",
+			"http-equiv",
+			"itemprop",
+		},
+		Singleton: []string{
+			"charset",
+		},
 	},
 
 	Tag{
@@ -35,6 +74,13 @@ var headTags = []Tag{
 			"type",
 			"sizes",
 		},
+		Key: []string{
+			"rel href",
+			"href",
+		},
+		Singleton: []string{
+			"rel=canonical",
+		},
 	},
 
 	Tag{
@@ -47,6 +93,9 @@ var headTags = []Tag{
 			"charset",
 			"defer",
 		},
+		Key: []string{
+			"src",
+		},
 	},
 
 	Tag{
@@ -56,263 +105,787 @@ var headTags = []Tag{
 			"media",
 			"type",
 		},
+		// e.g. the "nonce" of the content security policy
+		Key: []string{},
 	},
 }
 
 // SetDefaultHeadTags replaces default head tags and their attributes.
-// Only registered tags and their attributes can be merged.
+// Only registered tags can be merged, the tags without Key keep only the
+// registered attributes.
 func SetDefaultHeadTags(tags []Tag) {
 
+	tags = append([]Tag(nil), tags...)
+	policyMu.Lock()
 	headTags = tags
+	policyMu.Unlock()
+}
+
+// HeadTagPolicy is the policy of merging the head tags of the same key, e.g.
+// the <meta name="description"> of the pages.
+type HeadTagPolicy int
+
+const (
+	// The tag of the last page wins.
+	HeadLastWins HeadTagPolicy = iota
+
+	// The tag of the first page wins.
+	HeadFirstWins
+)
+
+var headTagPolicy = HeadLastWins
+
+// SetHeadTagPolicy sets the policy of merging the head tags of the same key,
+// see Tag. By default the tag of the last page wins.
+func SetHeadTagPolicy(policy HeadTagPolicy) {
+
+	policyMu.Lock()
+	headTagPolicy = policy
+	policyMu.Unlock()
+}
+
+// AttrPolicy is the policy of merging an attribute of the <html> or <body>
+// elements of the pages.
+type AttrPolicy int
+
+const (
+	// The attribute is dropped.
+	AttrIgnore AttrPolicy = iota
+
+	// The value of the first page which has the attribute wins.
+	AttrFirstWins
+
+	// The value of the last page which has the attribute wins.
+	AttrLastWins
+
+	// The space-separated values of all pages are merged, e.g. the class
+	// lists.
+	AttrUnion
+)
+
+// RootAttrPolicy is the policies of merging the attributes of a root element,
+// e.g. <html> or <body>.
+type RootAttrPolicy struct {
+	// The policies of the attributes, e.g. {"class": AttrUnion}.
+	Attrs   map[string]AttrPolicy
+
+	// The policy of the attributes which are not in Attrs.
+	Default AttrPolicy
+}
+
+func (p RootAttrPolicy) policy(key string) AttrPolicy {
+	if policy, ok := p.Attrs[key]; ok {
+		return policy
+	}
+	return p.Default
+}
+
+// the attributes of <html> are merged by default, e.g. lang and dir
+var htmlAttrPolicy = RootAttrPolicy{
+	Attrs: map[string]AttrPolicy{"class": AttrUnion},
+	Default: AttrLastWins,
+}
+
+// the attributes of <body> stay on the <div> of every page by default
+var bodyAttrPolicy = RootAttrPolicy{
+	Default: AttrIgnore,
+}
+
+// SetHtmlAttrPolicy sets the policies of merging the attributes of the <html>
+// elements of pages. By default the class lists are merged and the last page
+// wins for the other attributes.
+func SetHtmlAttrPolicy(policy RootAttrPolicy) {
+
+	policy = policy.copy()
+	policyMu.Lock()
+	htmlAttrPolicy = policy
+	policyMu.Unlock()
 }
 
-// MergeHtml merges multiple html pages into one single page.
+// SetBodyAttrPolicy sets the policies of merging the attributes of the <body>
+// elements of pages into the merged <body>, the <div> of every page always
+// keeps the attributes of its page. By default no attribute is merged.
+func SetBodyAttrPolicy(policy RootAttrPolicy) {
+
+	policy = policy.copy()
+	policyMu.Lock()
+	bodyAttrPolicy = policy
+	policyMu.Unlock()
+}
+
+// copy returns a copy of the policy, so the caller can not change it while
+// merging.
+func (p RootAttrPolicy) copy() RootAttrPolicy {
+	attrs := make(map[string]AttrPolicy, len(p.Attrs))
+	for key, policy := range p.Attrs {
+		attrs[key] = policy
+	}
+	p.Attrs = attrs
+	return p
+}
+
+// attribute is an attribute of a tag, the value is unescaped.
+type attribute struct {
+	key string
+	val string
+}
+
+// mergeRootAttr merges the attribute lists of pages by the policy, the
+// attributes keep the order they first appear. The template actions out of
+// the attribute values can not be merged, they are skipped.
+func mergeRootAttr(lists [][]attribute, policy RootAttrPolicy) []attribute {
+	var merged []attribute
+	index := make(map[string]int)
+	for _, attrs := range lists {
+		for _, a := range attrs {
+			p := policy.policy(a.key)
+			if p == AttrIgnore || isAction(a.key) {
+				continue
+			}
+			i, ok := index[a.key]
+			if !ok {
+				index[a.key] = len(merged)
+				if p == AttrUnion {
+					a.val = unionFields("", a.val)
+				}
+				merged = append(merged, a)
+				continue
+			}
+			switch p {
+			case AttrLastWins:
+				merged[i].val = a.val
+			case AttrUnion:
+				merged[i].val = unionFields(merged[i].val, a.val)
+			}
+		}
+	}
+	return merged
+}
+
+// unionFields appends the space-separated fields of b to a, the duplicated
+// fields are skipped. The values with template actions are appended as a
+// whole.
+func unionFields(a, b string) string {
+	if strings.Contains(b, "{{") {
+		if a == "" {
+			return b
+		}
+		return a + " " + b
+	}
+	fields := strings.Fields(a)
+	for _, f := range strings.Fields(b) {
+		exist := false
+		for _, field := range fields {
+			if field == f {
+				exist = true
+				break
+			}
+		}
+		if !exist {
+			fields = append(fields, f)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// writeAttrs writes the attributes, the values with template actions are
+// written as they are, e.g. class='{{if eq . "x"}}dark{{end}}'.
+func writeAttrs(buf *bytes.Buffer, attrs []attribute) {
+	for _, a := range attrs {
+		switch {
+		case !strings.Contains(a.val, "{{"):
+			buf.WriteString(" " + a.key + `="` + attrEscaper.Replace(a.val) + `"`)
+		case strings.Contains(a.val, `"`):
+			buf.WriteString(" " + a.key + `='` + a.val + `'`)
+		default:
+			buf.WriteString(" " + a.key + `="` + a.val + `"`)
+		}
+	}
+}
+
+// isAction reports whether the attribute key is a part of a template action,
+// e.g. the keys "{{if", ".dark}}class" and "{{end}}" of
+// <html {{if .Dark}}class="dark"{{end}}>.
+func isAction(key string) bool {
+
+	return strings.Contains(key, "{{") || strings.Contains(key, "}}")
+}
+
+// sameAttrs reports whether the attribute lists are equal.
+func sameAttrs(a, b []attribute) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// MergeError means a page can not be merged, e.g. it has no <body>.
+type MergeError struct {
+	// The index of the page in the merged pages.
+	Index int
+
+	// The page which got the error, it is set by the Container.
+	Page  *Page
+
+	// The error message.
+	Err   string
+}
+
+func (me *MergeError) Error() string {
+	if me.Page != nil {
+		return fmt.Sprintf("Merge html page got error: %s;\nError from: %s", me.Err, filepath.Join(me.Page.Dir, me.Page.File))
+	}
+	return fmt.Sprintf("Merge html page got error: %s;\nError from: page %d", me.Err, me.Index)
+}
+
+// MergeErrors holds the errors of all the pages which can not be merged.
+type MergeErrors []*MergeError
+
+func (mes MergeErrors) Error() string {
+	msgs := make([]string, len(mes))
+	for i, me := range mes {
+		msgs[i] = me.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// MergeHtml merges multiple html pages into one single page. The pages are
+// split by an html tokenizer, so the tags in comments, scripts and attribute
+// values are not mistaken for page sections. The malformed parts of pages
+// are merged as well as possible, see MergeHtmlE for checking the pages.
 func MergeHtml(pages [][]byte) []byte {
+	merged, _ := mergeHtml(pages)
+	return merged
+}
+
+// MergeHtmlE merges the pages like MergeHtml, but returns MergeErrors if any
+// page is malformed, e.g. it has no <body>, or its <head>, <title> or <body>
+// is not closed.
+func MergeHtmlE(pages [][]byte) ([]byte, error) {
+	merged, errs := mergeHtml(pages)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return merged, nil
+}
+
+func mergeHtml(pages [][]byte) ([]byte, MergeErrors) {
 	var (
-		heads = make([][]byte, len(pages))
-		bodies = make([][]byte, len(pages))
-		scripts = make([][][]byte, len(pages))
+		errs MergeErrors
+		parsed = make([]*htmlPage, len(pages))
 		headCache = make(map[string]map[string]int)
-		titleIndex int
+		// the key of a head tag -> its text in the headCache
+		headKeys = make(map[string]string)
+		// the text of a head tag -> the text with its marks
+		headTexts = make(map[string][]byte)
+		titleIndex = -1
+		prefixIndex = -1
+		htmlAttrs = make([][]attribute, len(pages))
+		bodyAttrs = make([][]attribute, len(pages))
 	)
+	policyMu.RLock()
+	tags, headPolicy := headTags, headTagPolicy
+	htmlPolicy, bodyPolicy := htmlAttrPolicy, bodyAttrPolicy
+	policyMu.RUnlock()
 
 	// get all sections
 	for idx, p := range pages {
-		// get all heads
-		head, _ := getFirstSection(p, "head")
-		heads[idx] = head
-
-		// get all body
-		body := getFirstSectionWithTag(p, "body")
-		bodies[idx] = body
-
-		// get all script
-		ss := newSection(p[bytes.LastIndex(p, []byte("</body>")):], "script")
-		for ss.NextWithTag(func(tagContent []byte) {
-			scripts[idx] = append(scripts[idx], tagContent)
-		}) {}
-	}
-
-	// merge all heads
-	for idx, h := range heads {
-		if bytes.Index(h, []byte("<title")) != -1 {
+		parsed[idx] = splitHtml(p, tags)
+		htmlAttrs[idx] = parsed[idx].htmlAttr
+		bodyAttrs[idx] = parsed[idx].bodyAttrs
+		for _, err := range parsed[idx].errs {
+			errs = append(errs, &MergeError{Index: idx, Err: err})
+		}
+		if parsed[idx].title != nil {
 			// mark the last title
 			titleIndex = idx
 		}
+		if prefixIndex == -1 && parsed[idx].prefix != nil {
+			prefixIndex = idx
+		}
+	}
+
+	// merge all heads
+	for idx, p := range parsed {
 		// format "<head>...</head>"
-		for _, tag := range headTags {
-			section := newSection(h, tag.Name)
-			priority := 0
-			for section.Next(func(content []byte, attr map[string]string) {
-				priority++
-				current := (idx << 8) + priority
-				buf := bytes.NewBuffer(nil)
+		priorities := make(map[string]int)
+		for _, t := range p.heads {
+			tag := t.tag
+			priorities[tag.Name]++
+			current := (idx << 8) + priorities[tag.Name]
+			buf := bytes.NewBuffer(nil)
+			if tag.Key != nil {
+				// the start tag with all its attributes
+				buf.Write(t.raw)
+				if tag.HasContent {
+					buf.Write(t.content)
+					buf.WriteString("</")
+					buf.WriteString(tag.Name)
+					buf.WriteRune('>')
+				}
+			} else {
 				buf.WriteString("<")
 				buf.WriteString(tag.Name)
 				for _, a := range tag.Attr {
-					kv := mergeAttr(attr, a)
+					kv := mergeAttr(t.attr, a)
 					buf.Write(kv)
 				}
 				if tag.HasContent {
 					buf.WriteRune('>')
-					buf.Write(content)
+					buf.Write(t.content)
 					buf.WriteString("</")
 					buf.WriteString(tag.Name)
 					buf.WriteRune('>')
 				} else {
 					buf.WriteString("/>")
 				}
-				aStr := buf.String()
-				// cover the same value
-				if headCache[tag.Name] == nil {
-					headCache[tag.Name] = map[string]int{aStr: current}
-				} else {
-					headCache[tag.Name][aStr] = current
+			}
+			// the marks of the pages differ, the tags are compared without
+			// them
+			aStr := string(stripMarks(buf.Bytes()))
+			key := tag.Name + " " + aStr
+			if k := t.key(); k != "" {
+				key = tag.Name + " " + k
+			}
+			// cover the tag of the same key
+			if old, ok := headKeys[key]; ok {
+				if headPolicy == HeadFirstWins {
+					continue
 				}
-			}) {}
+				delete(headCache[tag.Name], old)
+			}
+			headKeys[key] = aStr
+			headTexts[aStr] = withMark(t.mark, buf.Bytes())
+			if headCache[tag.Name] == nil {
+				headCache[tag.Name] = map[string]int{aStr: current}
+			} else {
+				headCache[tag.Name][aStr] = current
+			}
 		}
 	}
 
 	buffer := bytes.NewBuffer(nil)
-	// read the last title
-	doc := pages[titleIndex]
-	part := doc[:bytes.Index(doc, []byte("<head>")) + 6]
-	// write start file like "<!DOCTYPE html><head>" into buffer
-	buffer.Write(part)
-	buffer.WriteString("\n    ")
-	doc = doc[bytes.Index(doc, []byte("<title>")): bytes.Index(doc, []byte("</title>")) + 8]
-	// write the last title "<title>...</title>" into buffer
-	buffer.Write(doc)
+	// write start file like "<!DOCTYPE html><html><head>" into buffer, it
+	// comes from the page of the last title, the <html> gets the merged
+	// attributes
+	htmlAttr := mergeRootAttr(htmlAttrs, htmlPolicy)
+	// the template actions out of the attribute values can not be merged,
+	// e.g. <html {{if .Dark}}class="dark"{{end}}>
+	rawHtml := false
+	for _, attrs := range htmlAttrs {
+		for _, a := range attrs {
+			rawHtml = rawHtml || isAction(a.key)
+		}
+	}
+	// the title may come from a page without "<head>"
+	startIndex := titleIndex
+	if startIndex == -1 || parsed[startIndex].prefix == nil {
+		startIndex = prefixIndex
+	}
+	if startIndex != -1 && parsed[startIndex].htmlEnd > 0 && (rawHtml || sameAttrs(htmlAttr, parsed[startIndex].htmlAttr)) {
+		// nothing is changed by the policy, copy the start tag
+		buffer.Write(parsed[startIndex].prefix)
+	} else if startIndex != -1 && parsed[startIndex].htmlEnd > 0 {
+		p := parsed[startIndex]
+		buffer.Write(p.prefix[:p.htmlStart])
+		buffer.WriteString("<html")
+		writeAttrs(buffer, htmlAttr)
+		buffer.WriteString(">")
+		buffer.Write(p.prefix[p.htmlEnd:])
+	} else if startIndex != -1 {
+		buffer.Write(parsed[startIndex].prefix)
+	} else {
+		buffer.WriteString("<!DOCTYPE html>\n<html")
+		writeAttrs(buffer, htmlAttr)
+		buffer.WriteString(">\n<head>")
+	}
+	if titleIndex != -1 && parsed[titleIndex].title != nil {
+		// write the last title "<title>...</title>" into buffer
+		buffer.WriteString("\n    ")
+		buffer.Write(parsed[titleIndex].title)
+	}
 
 	// write all head tags into buffer
-	for _, tag := range headTags {
+	for _, tag := range tags {
 		if ms, ok := headCache[tag.Name]; ok {
 			sms := sorter.NewPrioritySorter(ms).Sort()
 			for _, val := range sms {
 				buffer.WriteString("\n    ")
-				buffer.WriteString(val)
+				buffer.Write(headTexts[val])
 			}
 		}
 	}
 
-	buffer.WriteString("\n</head>\n<body>")
+	buffer.WriteString("\n</head>\n<body")
+	writeAttrs(buffer, mergeRootAttr(bodyAttrs, bodyPolicy))
+	buffer.WriteString(">")
 
-	// write all body into buffer
-	for _, body := range bodies {
-		if body != nil {
+	// write all body into buffer, turn "<body ...>...</body>" to
+	// "<div ...>...</div>"
+	for _, p := range parsed {
+		if p.hasBody {
 			buffer.WriteString("\n<div")
-			buffer.Write(body[5:len(body) - 5]) // turn "<body" ... "body>" to "<div" ... "div>"
-			buffer.WriteString("div>")
+			buffer.Write(p.bodyAttr)
+			buffer.WriteString(">")
+			buffer.Write(p.body)
+			buffer.WriteString("</div>")
 		}
 	}
 	buffer.WriteString("\n</body>")
 
-	// write all script tags which after body
-	for _, ss := range scripts {
-		for _, script := range ss {
-			if script != nil {
-				buffer.WriteString("\n")
-				buffer.Write(script)
-			}
+	// write all script tags which out of the head and the body
+	for _, p := range parsed {
+		for _, script := range p.scripts {
+			buffer.WriteString("\n")
+			buffer.Write(script)
 		}
 	}
 	// close html tag
 	buffer.WriteString("\n</html>")
-	return buffer.Bytes()
+	return buffer.Bytes(), errs
 }
 
 func mergeAttr(attr map[string]string, key string) (kv []byte) {
 	if v, ok := attr[key]; ok {
-		kv = append([]byte(` ` + key + `="`), v...)
+		kv = append([]byte(` ` + key + `="`), attrEscaper.Replace(v)...)
 		kv = append(kv, []byte(`"`)...)
 	}
 	return
 }
 
-type section struct {
-	page   []byte
-	tag    string
-	tagLen int
-}
+// the tokenizer unescapes the attribute values
+var attrEscaper = strings.NewReplacer(`&`, "&amp;", `"`, "&#34;")
 
-func newSection(page []byte, tag string) *section {
-	return &section{page: page, tag: tag, tagLen: len(tag)}
-}
+// htmlPage is a page split into the parts for merging.
+type htmlPage struct {
+	// The start of the page to the end of "<head>", e.g.
+	// "<!DOCTYPE html>\n<html>\n<head>".
+	prefix   []byte
 
-func getFirstSection(page []byte, tag string) (content []byte, attr map[string]string) {
-	s := newSection(page, tag)
-	s.Next(func(tagContent []byte, att map[string]string) {
-		content = tagContent
-		attr = att
-	})
-	return
-}
+	// The offsets of "<html ...>" in the prefix, and its attributes.
+	htmlStart int
+	htmlEnd   int
+	htmlAttr  []attribute
 
-func getFirstSectionWithTag(page []byte, tag string) (tagContent []byte) {
-	s := newSection(page, tag)
-	s.NextWithTag(func(tag []byte) {
-		tagContent = tag
-	})
-	return
+	// The "<title>...</title>" in the head, or before "<body>" if the page
+	// has no "<head>".
+	title    []byte
+
+	// The registered tags in the head, or before "<body>" if the page has no
+	// "<head>".
+	heads    []headTag
+
+	// The raw attributes of "<body ...>" and the content of the body.
+	hasBody  bool
+	bodyAttr []byte
+	bodyAttrs []attribute
+	body     []byte
+
+	// The script elements out of the head and the body.
+	scripts  [][]byte
+
+	// The problems of the page structure.
+	errs     []string
 }
 
-func (s *section) NextWithTag(success func(tag []byte)) bool {
-	start := bytes.Index(s.page, []byte("<" + s.tag))
-	if start != -1 {
-		end := bytes.Index(s.page, []byte("</" + s.tag + ">")) + s.tagLen + 3
-		success(s.page[start: end])
-		s.page = s.page[end:]
-		return true
-	}
-	return false
+type headTag struct {
+	tag     Tag
+	// The start tag, e.g. `<link rel="stylesheet" href="/a.css"/>`.
+	raw     []byte
+	attr    map[string]string
+	content []byte
+
+	// The mark of the line where the tag starts.
+	mark    []byte
 }
 
-func (s *section) Next(success func(tagContent []byte, attr map[string]string)) bool {
-	start := bytes.Index(s.page, []byte("<" + s.tag))
-	var content []byte
-	var attr map[string]string
-	if start != -1 {
-		var nextStart int
-		startContent := s.page[start:]
-		close := bytes.Index(startContent, []byte(">"))
-		if close > 1 {
-			if startContent[close-1] == '/' {
-				close--
+// key returns the first Singleton the tag matches, e.g. "charset", or the
+// attributes of the first Key with their values, e.g.
+// `rel=stylesheet href=/a.css`, or "" if the tag has none.
+func (t headTag) key() string {
+	for _, s := range t.tag.Singleton {
+		k, v := s, ""
+		if i := strings.IndexByte(s, '='); i != -1 {
+			k, v = s[:i], s[i + 1:]
+		}
+		if val, ok := t.attr[k]; ok {
+			if v == "" {
+				return s
+			}
+			// e.g. rel="canonical alternate"
+			for _, f := range strings.Fields(val) {
+				if strings.EqualFold(f, v) {
+					return s
+				}
+			}
+		}
+	}
+next:
+	for _, key := range t.tag.Key {
+		var pairs []string
+		for _, k := range strings.Fields(key) {
+			v, ok := t.attr[k]
+			if !ok {
+				continue next
 			}
+			pairs = append(pairs, k + "=" + v)
 		}
-		attrStart := 1 + s.tagLen // "<tag "
-		if attrStart < close {
-			attr = getAttr(startContent[attrStart:close])
-			nextStart = start + close + 1
+		return strings.Join(pairs, " ")
+	}
+	return ""
+}
+
+// splitHtml splits the page by the html tokenizer, tags are the registered
+// head tags. The page may be marked by the combiners, the marks are kept in
+// the title, the head tags, the body and the scripts. The "<head>" may be
+// omitted as HTML5 allows, the head elements before "<body>" are in the
+// implicit head then, e.g. <html><title>...</title><body>.
+func splitHtml(page []byte, tags []Tag) *htmlPage {
+	p := &htmlPage{}
+	z := newTokenizer(page)
+	inHead, inBody, headClosed := false, false, false
+	bodyStart := 0
+	// the scripts of the implicit head, they are out of the head if the
+	// page has no body either
+	var implicitScripts [][]byte
+	for {
+		tt, start, end := z.next()
+		if tt == html.ErrorToken {
+			break
 		}
-		if close > 0 {
-			end := bytes.Index(startContent, []byte("</" + s.tag + ">"))
-			if end > close {
-				content = startContent[close + 1: end]
-				nextStart = start + end + 3 + s.tagLen
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			implicitHead := p.prefix == nil && !p.hasBody
+			switch {
+			case inBody:
+			case string(name) == "html" && p.prefix == nil && p.htmlEnd == 0:
+				p.htmlStart, p.htmlEnd = start, end
+				p.htmlAttr = tagAttrs(z.Tokenizer, hasAttr)
+			case string(name) == "head" && p.prefix == nil:
+				p.prefix = page[:end]
+				inHead = true
+			case string(name) == "body":
+				inHead, inBody = false, true
+				p.hasBody = true
+				// "<body" ... ">"
+				p.bodyAttr = page[start + 5:end - 1]
+				p.bodyAttrs = tagAttrs(z.Tokenizer, hasAttr)
+				bodyStart = end
+			case (inHead || implicitHead) && string(name) == "title":
+				_, endEnd, closed := z.skipTo(tt, "title")
+				p.title = page[start:endEnd]
+				if !closed {
+					p.errs = append(p.errs, "<title> is not closed")
+				}
+			case inHead || implicitHead && isHeadTag(tags, string(name)):
+				for _, tag := range tags {
+					if tag.Name == string(name) {
+						t := headTag{
+							tag: tag,
+							raw: page[start:end],
+							attr: tagAttr(z.Tokenizer, hasAttr),
+							mark: lastMark(page[:start]),
+						}
+						endEnd := end
+						if tag.HasContent && tt == html.StartTagToken {
+							var endStart int
+							endStart, endEnd, _ = z.skipTo(tt, tag.Name)
+							t.content = page[end:endStart]
+						}
+						p.heads = append(p.heads, t)
+						if implicitHead && tag.Name == "script" {
+							implicitScripts = append(implicitScripts, page[start:endEnd])
+						}
+						break
+					}
+				}
+			case string(name) == "script":
+				_, endEnd, _ := z.skipTo(tt, "script")
+				p.scripts = append(p.scripts, page[start:endEnd])
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "head":
+				inHead = false
+				headClosed = true
+			case "body", "html":
+				if inBody {
+					p.body = page[bodyStart:start]
+					inBody = false
+				}
 			}
 		}
-		s.page = s.page[nextStart:]
-		success(content, attr)
-		return true
-	} else {
-		return false
 	}
+	if inBody {
+		// the body is not closed
+		p.body = page[bodyStart:]
+		p.errs = append(p.errs, "<body> is not closed")
+	}
+	if p.prefix != nil && !headClosed {
+		p.errs = append(p.errs, "<head> is not closed")
+	}
+	if !p.hasBody {
+		p.errs = append(p.errs, "the page has no <body>")
+	}
+	if !p.hasBody && p.prefix == nil {
+		// a fragment has no implicit head, e.g. the scripts of a page
+		p.title, p.heads = nil, nil
+		p.scripts = append(implicitScripts, p.scripts...)
+	}
+	return p
+}
+
+// isHeadTag reports whether the tag name is a registered head tag.
+func isHeadTag(tags []Tag, name string) bool {
+	for _, tag := range tags {
+		if tag.Name == name {
+			return true
+		}
+	}
+	return false
 }
 
-func getAttr(n []byte) (attr map[string]string) {
+// tokenizer is an html tokenizer which tracks the offsets of the tokens.
+type tokenizer struct {
+	*html.Tokenizer
+	offset int
+}
 
-	a := TrimHtmlSpace(n)
+func newTokenizer(page []byte) *tokenizer {
+	return &tokenizer{Tokenizer: html.NewTokenizer(bytes.NewReader(page))}
+}
 
-	attr = make(map[string]string)
-	left := a
-	var key, value []rune
+// next returns the next token type and its offsets in the page.
+func (z *tokenizer) next() (tt html.TokenType, start, end int) {
+	tt = z.Next()
+	start = z.offset
+	z.offset += len(z.Raw())
+	return tt, start, z.offset
+}
+
+// skipTo skips to the end tag of the element which just started, the nested
+// elements of the same tag are skipped, returns the offsets of the end tag,
+// or the end of the page if the element is not closed. The void element has
+// no end tag, the offsets are of the start tag end.
+func (z *tokenizer) skipTo(tt html.TokenType, tag string) (start, end int, closed bool) {
+	if tt == html.SelfClosingTagToken || voidElements[tag] {
+		return z.offset, z.offset, true
+	}
+	depth := 1
 	for {
-		key, left = readKey(left)
-		if len(left) <= 1 {
-			attr[string(key)] = ""
-			return
-		}
-		value, left = readValue(left)
-		attr[string(key)] = string(value)
-		if len(left) <= 1 {
-			return
+		tt, start, end := z.next()
+		switch tt {
+		case html.ErrorToken:
+			return z.offset, z.offset, false
+		case html.StartTagToken:
+			if name, _ := z.TagName(); string(name) == tag {
+				depth++
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == tag {
+				depth--
+				if depth == 0 {
+					return start, end, true
+				}
+			}
 		}
 	}
+}
 
-	return
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
 }
 
-func readKey(s []rune) (key []rune, left []rune) {
-	if s[0] == ' ' { // cut the first space
-		s = s[1:]
+// tagAttr returns the attributes of the current tag, or nil if the tag has no
+// attributes.
+func tagAttr(z *html.Tokenizer, hasAttr bool) (attr map[string]string) {
+	if !hasAttr {
+		return nil
 	}
-	for _, b := range s {
-		if b == '=' || b == ' ' {
-			left = s[len(key):]
-			break
-		} else {
-			key = append(key, rune(b))
-		}
+	attr = make(map[string]string)
+	for _, a := range tagAttrs(z, hasAttr) {
+		attr[a.key] = a.val
 	}
 	return
 }
 
-func readValue(s []rune) (value []rune, left []rune) {
-	if len(s)>2 && string(s[0:2]) == `="` {
-		s = s[2:]
-		for _, b := range s {
-			if b == '"' {
-				left = s[len(value) + 1:] // cut the last `"`
-				break
-			} else {
-				value = append(value, rune(b))
+// tagAttrs returns the attributes of the current tag in order.
+func tagAttrs(z *html.Tokenizer, hasAttr bool) (attrs []attribute) {
+	for hasAttr {
+		var key, value []byte
+		key, value, hasAttr = z.TagAttr()
+		// the attributes may be marked if the tag has multiple lines
+		attrs = append(attrs, attribute{key: string(stripMarks(key)), val: string(stripMarks(value))})
+	}
+	return
+}
+
+type section struct {
+	page   []byte
+	tag    string
+}
+
+// NewSection returns a section reader which reads the elements of the tag
+// from the page in order.
+func NewSection(page []byte, tag string) *section {
+
+	return &section{page: page, tag: tag}
+}
+
+// nextElement finds the next element of the tag, returns the offsets of the
+// whole element and its content.
+func (s *section) nextElement() (start, contentStart, contentEnd, end int, attr map[string]string, ok bool) {
+	z := newTokenizer(s.page)
+	for {
+		tt, start, contentStart := z.next()
+		switch tt {
+		case html.ErrorToken:
+			return 0, 0, 0, 0, nil, false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) == s.tag {
+				attr = tagAttr(z.Tokenizer, hasAttr)
+				contentEnd, end, closed := z.skipTo(tt, s.tag)
+				if !closed {
+					contentEnd, end = contentStart, contentStart
+				}
+				return start, contentStart, contentEnd, end, attr, true
 			}
 		}
-	} else {
-		left = s
 	}
-	return
+}
+
+// NextWithTag reads the next element with its start and end tags.
+func (s *section) NextWithTag(success func(tag []byte)) bool {
+	start, _, _, end, _, ok := s.nextElement()
+	if !ok {
+		return false
+	}
+	element := s.page[start:end]
+	s.page = s.page[end:]
+	success(element)
+	return true
+}
+
+// Next reads the content and the attributes of the next element.
+func (s *section) Next(success func(tagContent []byte, attr map[string]string)) bool {
+	_, contentStart, contentEnd, end, attr, ok := s.nextElement()
+	if !ok {
+		return false
+	}
+	content := s.page[contentStart:contentEnd]
+	s.page = s.page[end:]
+	success(content, attr)
+	return true
 }
 
 var skipSpaceByPrev = func(prev rune) bool {
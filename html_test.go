@@ -206,4 +206,262 @@ func BenchmarkMergeHtml(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		view.MergeHtml(mergeData[0:6])
 	}
-}
\ No newline at end of file
+}
+func TestMergeHtmlTokenizer(t *testing.T) {
+	pages := [][]byte{
+		[]byte(`<html>
+<head>
+    <title>title-1</title>
+    <!-- <link rel="stylesheet" href="/commented.css"/> -->
+    <linkedin-widget data-id="1"></linkedin-widget>
+    <link rel="stylesheet" href="/local-1.css"/>
+</head>
+<body class="class-1"><div><div>nested</div></div>
+<script>if (a < b) { document.write("<script></script>") }</script>
+</body>
+</html>`),
+		// the body is not closed
+		[]byte(`<html>
+<body class="class-2">unclosed`),
+	}
+	expect := `<html>
+<head>
+    <title>title-1</title>
+    <link rel="stylesheet" href="/local-1.css"/>
+</head>
+<body>
+<div class="class-1"><div><div>nested</div></div>
+<script>if (a < b) { document.write("<script></script>") }</script>
+</div>
+<div class="class-2">unclosed</div>
+</body>
+</html>`
+	if got := string(view.MergeHtml(pages)); got != expect {
+		t.Errorf("got:\n%s\nexpect:\n%s", got, expect)
+	}
+}
+
+func TestSectionNested(t *testing.T) {
+	var got []string
+	section := view.NewSection([]byte(`<div>a<div>b</div></div><!-- <div> --><div>c</div>`), "div")
+	for section.NextWithTag(func(tag []byte) {
+		got = append(got, string(tag))
+	}) {}
+	expect := []string{`<div>a<div>b</div></div>`, `<div>c</div>`}
+	if !reflect.DeepEqual(got, expect) {
+		t.Error("got:", got, "expect:", expect)
+	}
+}
+
+func TestMergeHtmlE(t *testing.T) {
+	if _, err := view.MergeHtmlE(mergeData[0:3]); err != nil {
+		t.Fatal(err)
+	}
+	_, err := view.MergeHtmlE([][]byte{
+		mergeData[0],
+		[]byte(`<html><head><title>unclosed</html>`),
+		mergeData[4],
+	})
+	errs, ok := err.(view.MergeErrors)
+	if !ok {
+		t.Fatal("got error:", err, "expect view.MergeErrors")
+	}
+	var got []int
+	for _, me := range errs {
+		got = append(got, me.Index)
+	}
+	// page 1 has no <body>, and its <title> and <head> are not closed
+	if expect := []int{1, 1, 1, 2}; !reflect.DeepEqual(got, expect) {
+		t.Error("got error pages:", got, "expect:", expect, err)
+	}
+}
+
+func TestMergeHtmlImplicitHead(t *testing.T) {
+	pages := [][]byte{
+		[]byte(`<html lang="en"><title>1</title><meta name="a" content="1"><body>1</body></html>`),
+		[]byte(`<html><title>2</title>
+<link rel="stylesheet" href="/b.css">
+<body>2</body></html>`),
+	}
+	expect := `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <title>2</title>
+    <meta name="a" content="1">
+    <link rel="stylesheet" href="/b.css">
+</head>
+<body>
+<div>1</div>
+<div>2</div>
+</body>
+</html>`
+	got, err := view.MergeHtmlE(pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != expect {
+		t.Errorf("got:\n%s\nexpect:\n%s", got, expect)
+	}
+}
+
+func TestMergeHtmlRootAttr(t *testing.T) {
+	pages := [][]byte{
+		[]byte(`<html lang="en" class="a"><head><title>1</title></head><body class="x" id="first">1</body></html>`),
+		[]byte(`<html lang="fr" class="b a" data-theme="dark"><body class="y x" id="second">2</body></html>`),
+	}
+	expect := `<html lang="fr" class="a b" data-theme="dark"><head>
+    <title>1</title>
+</head>
+<body>
+<div class="x" id="first">1</div>
+<div class="y x" id="second">2</div>
+</body>
+</html>`
+	if got := string(view.MergeHtml(pages)); got != expect {
+		t.Errorf("got:\n%s\nexpect:\n%s", got, expect)
+	}
+
+	view.SetBodyAttrPolicy(view.RootAttrPolicy{
+		Attrs: map[string]view.AttrPolicy{"class": view.AttrUnion},
+		Default: view.AttrFirstWins,
+	})
+	defer view.SetBodyAttrPolicy(view.RootAttrPolicy{Default: view.AttrIgnore})
+	if got := string(view.MergeHtml(pages)); !bytes.Contains([]byte(got), []byte(`<body class="x y" id="first">`)) {
+		t.Errorf("got:\n%s\nexpect the merged body attributes", got)
+	}
+}
+
+func TestMergeHtmlHeadTagKeys(t *testing.T) {
+	pages := [][]byte{
+		[]byte(`<html><head><title>1</title>
+    <meta property="og:title" content="One">
+    <link rel="stylesheet" href="/a.css" integrity="sha384-a" crossorigin="anonymous">
+    <script src="/a.js" nonce="n1" data-main="app"></script>
+</head><body>1</body></html>`),
+		[]byte(`<html><head>
+    <meta property="og:title" content="Two">
+    <link rel="stylesheet" href="/a.css" integrity="sha384-b" crossorigin="anonymous">
+    <link rel="preload" href="/a.css" as="style">
+    <script>inline</script>
+</head><body>2</body></html>`),
+	}
+	expect := `<html><head>
+    <title>1</title>
+    <meta property="og:title" content="Two">
+    <link rel="stylesheet" href="/a.css" integrity="sha384-b" crossorigin="anonymous">
+    <link rel="preload" href="/a.css" as="style">
+    <script src="/a.js" nonce="n1" data-main="app"></script>
+    <script>inline</script>
+</head>
+<body>
+<div>1</div>
+<div>2</div>
+</body>
+</html>`
+	if got := string(view.MergeHtml(pages)); got != expect {
+		t.Errorf("got:\n%s\nexpect:\n%s", got, expect)
+	}
+}
+
+func TestMergeHtmlStyle(t *testing.T) {
+	pages := [][]byte{
+		[]byte(`<html><head><style nonce="n1" media="print">a{}</style></head><body>1</body></html>`),
+		[]byte(`<html><head><style nonce="n1" media="print">a{}</style><style>b{}</style></head><body>2</body></html>`),
+	}
+	got := view.MergeHtml(pages)
+	// the styles keep their attributes, the same styles are merged
+	for _, style := range []string{`<style nonce="n1" media="print">a{}</style>`, `<style>b{}</style>`} {
+		if n := bytes.Count(got, []byte(style)); n != 1 {
+			t.Errorf("got %d %s in:\n%s", n, style, got)
+		}
+	}
+}
+
+func TestMergeHtmlHeadSingleton(t *testing.T) {
+	pages := [][]byte{
+		[]byte(`<html><head>
+    <meta charset="utf-8">
+    <meta name="description" content="One">
+    <link rel="canonical" href="/one">
+</head><body>1</body></html>`),
+		[]byte(`<html><head>
+    <meta charset="gbk">
+    <meta name="description" content="Two">
+    <link rel="canonical" href="/two">
+</head><body>2</body></html>`),
+	}
+	heads := func(page []byte) string {
+		return string(page[:bytes.Index(page, []byte("\n</head>"))])
+	}
+	expect := `<html><head>
+    <meta charset="gbk">
+    <meta name="description" content="Two">
+    <link rel="canonical" href="/two">`
+	if got := heads(view.MergeHtml(pages)); got != expect {
+		t.Errorf("last wins got:\n%s\nexpect:\n%s", got, expect)
+	}
+
+	view.SetHeadTagPolicy(view.HeadFirstWins)
+	defer view.SetHeadTagPolicy(view.HeadLastWins)
+	expect = `<html><head>
+    <meta charset="utf-8">
+    <meta name="description" content="One">
+    <link rel="canonical" href="/one">`
+	if got := heads(view.MergeHtml(pages)); got != expect {
+		t.Errorf("first wins got:\n%s\nexpect:\n%s", got, expect)
+	}
+}
+
+func TestMergeHtmlRootAttrActions(t *testing.T) {
+	body := []byte(`<body>2</body></html>`)
+	tests := []struct {
+		first, second string
+		expect        string
+	}{
+		// nothing to merge, the start tag is copied
+		{`<html {{if .Dark}}class="dark"{{end}}>`, `<html>`, `<html {{if .Dark}}class="dark"{{end}}>`},
+		{`<html {{if .Dark}}class="dark"{{end}}>`, `<html lang="en">`, `<html {{if .Dark}}class="dark"{{end}}>`},
+		{`<html LANG=en>`, `<html>`, `<html LANG=en>`},
+		// the values are not escaped
+		{`<html class='{{if eq . "x"}}dark{{end}}'>`, `<html lang="en">`, `<html class='{{if eq . "x"}}dark{{end}}' lang="en">`},
+	}
+	for _, test := range tests {
+		pages := [][]byte{
+			[]byte(test.first + `<head><title>1</title></head><body>1</body></html>`),
+			append([]byte(test.second), body...),
+		}
+		got := view.MergeHtml(pages)
+		if !bytes.HasPrefix(got, []byte(test.expect + "<head>")) {
+			t.Errorf("got:\n%s\nexpect:\n%s", got, test.expect)
+		}
+	}
+}
+
+// run with -race
+func TestMergeHtmlConcurrentPolicy(t *testing.T) {
+	pages := [][]byte{
+		[]byte(`<html lang="en"><head><title>1</title></head><body class="a">1</body></html>`),
+		[]byte(`<html lang="fr"><body class="b">2</body></html>`),
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			view.SetHtmlAttrPolicy(view.RootAttrPolicy{Default: view.AttrLastWins})
+			view.SetBodyAttrPolicy(view.RootAttrPolicy{Default: view.AttrIgnore})
+			view.SetHeadTagPolicy(view.HeadLastWins)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			view.SetHtmlAttrPolicy(view.RootAttrPolicy{
+				Attrs: map[string]view.AttrPolicy{"class": view.AttrUnion},
+				Default: view.AttrLastWins,
+			})
+			return
+		default:
+		}
+		view.MergeHtml(pages)
+	}
+}
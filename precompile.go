@@ -0,0 +1,148 @@
+package view
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PrecompileError holds the errors of precompiling view files, e.g.
+// *ParseError and *CombineError.
+type PrecompileError struct {
+	Errors []error
+}
+
+func (pe *PrecompileError) Error() string {
+	msgs := make([]string, len(pe.Errors))
+	for i, err := range pe.Errors {
+		msgs[i] = err.Error()
+	}
+	return "Precompile view files got " + strconv.Itoa(len(pe.Errors)) + " errors:\n" + strings.Join(msgs, "\n\n")
+}
+
+// ViewFiles returns the names of the view files in the directory and its sub
+// directories, the names are slash-separated and have no extension, e.g.
+// "index" and "layouts/base", so they can be used as the File of pages.
+func (this *Container) ViewFiles(dir string) ([]string, error) {
+	var files []string
+	walk := func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), this.ext) {
+			return nil
+		}
+		var rel string
+		if this.fsys != nil {
+			rel = p
+			if root := path.Clean(dir); root != "." {
+				rel = strings.TrimPrefix(p, root + "/")
+			}
+		} else if rel, err = filepath.Rel(dir, p); err != nil {
+			return err
+		}
+		files = append(files, strings.TrimSuffix(filepath.ToSlash(rel), this.ext))
+		return nil
+	}
+	var err error
+	if this.fsys != nil {
+		err = fs.WalkDir(this.fsys, path.Clean(dir), walk)
+	} else {
+		err = filepath.WalkDir(dir, walk)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Precompile combines and parses the view files of the directory into the
+// cache, so the broken views can be found before the first request. The
+// errors of all files are returned as a *PrecompileError.
+func (this *Container) Precompile(dir string, files ...string) error {
+	ps := make([]Page, len(files))
+	for i, file := range files {
+		ps[i] = NewPage(dir, file)
+	}
+	return this.precompile(ps)
+}
+
+// precompile builds the template of every page.
+func (this *Container) precompile(ps []Page) error {
+	pe := &PrecompileError{}
+	for _, p := range ps {
+		if _, err := this.build(this.cacheName([]Page{p}), []Page{p}); err != nil {
+			pe.Errors = append(pe.Errors, err)
+		}
+	}
+	if len(pe.Errors) > 0 {
+		return pe
+	}
+	return nil
+}
+
+// PrecompileDir precompiles the pages of the directory and its sub
+// directories, see PageFiles and Precompile. The layouts and the included
+// files are compiled as parts of the pages, they may use the variables of the
+// pages, so they are not compiled alone.
+func (this *Container) PrecompileDir(dir string) error {
+	files, err := this.PageFiles(dir)
+	if err != nil {
+		return err
+	}
+	return this.Precompile(dir, files...)
+}
+
+// PageFiles returns the names of the view files of the directory and its sub
+// directories like ViewFiles, but without the files extended or included by
+// other files, e.g. layouts and partials. The files are combined to find
+// their dependencies, the errors of all files are returned as a
+// *PrecompileError.
+func (this *Container) PageFiles(dir string) ([]string, error) {
+
+	return this.pageFiles(dir, false)
+}
+
+// pageFiles returns the pages of the directory, the front matter of the files
+// is removed if frontMatter is true.
+func (this *Container) pageFiles(dir string, frontMatter bool) ([]string, error) {
+	files, err := this.ViewFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	// the files extended or included by other files
+	used := make(map[string]bool)
+	pe := &PrecompileError{}
+	for _, file := range files {
+		p := NewPage(dir, file)
+		p.frontMatter = frontMatter
+		c, err := this.combine([]Page{p}, false, false)
+		if err != nil {
+			pe.Errors = append(pe.Errors, err)
+			continue
+		}
+		for _, deps := range c.deps {
+			for _, dep := range deps {
+				used[dep] = true
+			}
+		}
+	}
+	if len(pe.Errors) > 0 {
+		return nil, pe
+	}
+	var pages []string
+	for _, file := range files {
+		filename, err := this.newCombiner(dir, "", false).filename(file, "")
+		if err != nil {
+			return nil, err
+		}
+		if !used[filename] {
+			pages = append(pages, file)
+		}
+	}
+	return pages, nil
+}
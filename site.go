@@ -0,0 +1,138 @@
+package view
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"gopkg.in/yaml.v3"
+)
+
+// BuildSite renders the pages of the view directory into html files of the
+// output directory, e.g. "views/blog/post.blade.php" is rendered into
+// "out/blog/post.html". The view files which are extended or included by
+// other files, e.g. layouts and partials, are not pages.
+//
+// The data of a page comes from its YAML front matter and the JSON data file
+// next to it, the data file takes precedence, e.g.
+//
+//	---
+//	title: Home
+//	---
+//	@extends("layouts/base")
+//	@section("title"){{.title}}@endsection
+//
+// and "views/index.json" for "views/index.blade.php".
+func (this *Container) BuildSite(dir, out string) error {
+	files, err := this.pageFiles(dir, true)
+	if err != nil {
+		return err
+	}
+	ps := make([]Page, len(files))
+	for i, file := range files {
+		ps[i] = NewPage(dir, file)
+		ps[i].frontMatter = true
+	}
+	// nothing is written if any page is broken
+	if err = this.precompile(ps); err != nil {
+		return err
+	}
+	for i, file := range files {
+		data, err := this.pageData(dir, file)
+		if err != nil {
+			return err
+		}
+		html, err := this.Render(data, ps[i])
+		if err != nil {
+			return err
+		}
+		filename := filepath.Join(out, filepath.FromSlash(file) + ".html")
+		if err = os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(filename, html, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pageData reads the front matter and the JSON data file of the page.
+func (this *Container) pageData(dir, file string) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	combiner := this.newCombiner(dir, "", false)
+	filename, err := combiner.filename(file, "")
+	if err != nil {
+		return nil, err
+	}
+	content, err := this.readFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if front, _, _ := splitFrontMatter(content); front != nil {
+		if err = yaml.Unmarshal(front, &data); err != nil {
+			return nil, fmt.Errorf("Read front matter of %s got error: %v", filename, err)
+		}
+	}
+	// e.g. "views/index.json"
+	combiner = this.newCombiner(dir, "", false)
+	combiner.ext = ".json"
+	if filename, err = combiner.filename(file, ""); err != nil {
+		return nil, err
+	}
+	content, err = this.readFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return data, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var fileData map[string]interface{}
+	if err = json.Unmarshal(content, &fileData); err != nil {
+		return nil, fmt.Errorf("Read data file %s got error: %v", filename, err)
+	}
+	for key, value := range fileData {
+		data[key] = value
+	}
+	return data, nil
+}
+
+func (this *Container) readFile(filename string) ([]byte, error) {
+	if this.fsys != nil {
+		return fs.ReadFile(this.fsys, filename)
+	}
+	return ioutil.ReadFile(filename)
+}
+
+// splitFrontMatter splits the YAML front matter between the "---" lines at
+// the top of the content, lines is the number of the lines of the front
+// matter. The front matter is nil if the content has none.
+func splitFrontMatter(content []byte) (front, body []byte, lines int) {
+	start := lineEnd(content, 0)
+	if start == -1 || string(bytes.TrimRight(content[:start], "\r\n")) != "---" {
+		return nil, content, 0
+	}
+	for i := start; i < len(content); {
+		end := lineEnd(content, i)
+		if end == -1 {
+			end = len(content)
+		}
+		if string(bytes.TrimRight(content[i:end], "\r\n")) == "---" {
+			return content[start:i], content[end:], bytes.Count(content[:end], []byte("\n"))
+		}
+		i = end
+	}
+	return nil, content, 0
+}
+
+// lineEnd returns the index after the "\n" of the line starting at i, or -1.
+func lineEnd(content []byte, i int) int {
+	n := bytes.IndexByte(content[i:], '\n')
+	if n == -1 {
+		return -1
+	}
+	return i + n + 1
+}
@@ -0,0 +1,62 @@
+package view_test
+
+import (
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"testing/fstest"
+	"gopkg.in/orivil/view.v0"
+)
+
+func TestBuildSite(t *testing.T) {
+	out := t.TempDir()
+	container := view.NewContainer(false, fileExt)
+	if err := container.BuildSite("./testdata/site", out); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{
+		"index.html": "<html>\n<title>Home</title>\n<body>\n    <nav>Orivil</nav>\n    <h1>Home</h1>\n</body>\n</html>\n",
+		"blog/post.html": "<article>Post: hello</article>\n",
+	}
+	for file, html := range expect {
+		got, err := ioutil.ReadFile(filepath.Join(out, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != html {
+			t.Errorf("got %s:\n%q\nexpect:\n%q", file, got, html)
+		}
+	}
+	// layouts and partials are not pages
+	for _, file := range []string{"layouts/base.html", "partials/nav.html"} {
+		if _, err := ioutil.ReadFile(filepath.Join(out, file)); err == nil {
+			t.Errorf("got page %s", file)
+		}
+	}
+}
+
+func TestFrontMatterPosition(t *testing.T) {
+	// the front matter is removed, the lines keep their positions
+	combiner := view.NewCombiner("./testdata/site", fileExt)
+	combiner.SetFrontMatter(true)
+	if _, err := combiner.Combine("index"); err != nil {
+		t.Fatal(err)
+	}
+	expect := view.Position{File: filepath.Join("testdata", "site", "index" + fileExt), Line: 8}
+	if got := combiner.SourceMap().LinePosition(5); got != expect {
+		t.Errorf("got position: %v, expect: %v", got, expect)
+	}
+}
+
+func TestFrontMatterOptIn(t *testing.T) {
+	views := fstest.MapFS{
+		"index.blade.php": {Data: []byte("---\nthis is not yaml front matter\n---\nhello")},
+	}
+	html, err := view.NewFSContainer(views, false, fileExt).Combine(view.NewPage(".", "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "---\nthis is not yaml front matter\n---\nhello"; string(html) != expect {
+		t.Errorf("got: %q, expect: %q", html, expect)
+	}
+}
@@ -0,0 +1,247 @@
+package view
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Position is a line of a view file.
+type Position struct {
+	// The file path, e.g. "views/layouts/base.blade.php".
+	File string
+
+	// The line number, starts from 1.
+	Line int
+}
+
+// IsValid reports whether the position is known.
+func (p Position) IsValid() bool {
+
+	return p.File != ""
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return p.File + ":" + strconv.Itoa(p.Line)
+}
+
+type segment struct {
+	offset int
+	pos    Position
+}
+
+// SourceMap records which file and line every byte range of the combined
+// content came from.
+type SourceMap struct {
+	content  []byte
+	segments []segment
+}
+
+// Position returns the source position of the byte offset of the combined
+// content.
+func (sm *SourceMap) Position(offset int) Position {
+	if sm == nil || offset < 0 || offset > len(sm.content) {
+		return Position{}
+	}
+	i := sort.Search(len(sm.segments), func(i int) bool {
+		return sm.segments[i].offset > offset
+	}) - 1
+	if i < 0 {
+		return Position{}
+	}
+	seg := sm.segments[i]
+	// empty lines have no marks
+	seg.pos.Line += bytes.Count(sm.content[seg.offset:offset], []byte("\n"))
+	return seg.pos
+}
+
+// LinePosition returns the source position of the line of the combined
+// content, the line number starts from 1, just like the line numbers of
+// template errors.
+func (sm *SourceMap) LinePosition(line int) Position {
+	if sm == nil || line < 1 {
+		return Position{}
+	}
+	offset := 0
+	for i := 1; i < line; i++ {
+		n := bytes.IndexByte(sm.content[offset:], '\n')
+		if n == -1 {
+			return Position{}
+		}
+		offset += n + 1
+	}
+	// the first non-space byte of the line carries the mark
+	for offset < len(sm.content) && (sm.content[offset] == ' ' || sm.content[offset] == '\t') {
+		offset++
+	}
+	return sm.Position(offset)
+}
+
+// SourceLine is a line of the combined content with its source position.
+type SourceLine struct {
+	Position Position
+	Text     string
+}
+
+// Lines returns the line of the combined content and n lines around it.
+func (sm *SourceMap) Lines(line, n int) []SourceLine {
+	if sm == nil {
+		return nil
+	}
+	var lines []SourceLine
+	for i, text := range strings.Split(string(sm.content), "\n") {
+		if l := i + 1; l >= line - n && l <= line + n {
+			lines = append(lines, SourceLine{
+				Position: sm.LinePosition(l),
+				Text: text,
+			})
+		}
+	}
+	return lines
+}
+
+// The combiner marks the first non-space byte of every line with its file
+// and line, like "\x1eviews/index.blade.php:3\x1f". Marks are zero-width for
+// the directives, and they will be moved along with the text when the files
+// are combined, at last the marks are removed and turned into a SourceMap.
+const (
+	markStart = '\x1e'
+	markEnd   = '\x1f'
+)
+
+var markPatten = "\x1e[^\x1f]*\x1f"
+
+// mark marks every line of the file content, line is the line number of the
+// first line.
+func mark(content []byte, file string, line int) []byte {
+	marked := make([]byte, 0, len(content) + len(content) / 8)
+	for len(content) > 0 {
+		end := bytes.IndexByte(content, '\n') + 1
+		if end == 0 {
+			end = len(content)
+		}
+		l := content[:end]
+		text := bytes.TrimLeft(l, " \t\r")
+		if len(text) > 0 && text[0] != '\n' {
+			marked = append(marked, l[:len(l) - len(text)]...)
+			marked = append(marked, markStart)
+			marked = append(marked, file...)
+			marked = append(marked, ':')
+			marked = strconv.AppendInt(marked, int64(line), 10)
+			marked = append(marked, markEnd)
+			marked = append(marked, text...)
+		} else {
+			marked = append(marked, l...)
+		}
+		content = content[end:]
+		line++
+	}
+	return marked
+}
+
+// unmark removes all marks of the content, returns the clean content and
+// its source map.
+func unmark(content []byte) ([]byte, *SourceMap) {
+	sm := &SourceMap{}
+	clean := make([]byte, 0, len(content))
+	for {
+		i := bytes.IndexByte(content, markStart)
+		if i == -1 {
+			break
+		}
+		j := bytes.IndexByte(content[i:], markEnd)
+		if j == -1 {
+			break
+		}
+		clean = append(clean, content[:i]...)
+		// the later mark covers the former one at the same offset
+		if n := len(sm.segments); n > 0 && sm.segments[n - 1].offset == len(clean) {
+			sm.segments = sm.segments[:n - 1]
+		}
+		sm.segments = append(sm.segments, segment{
+			offset: len(clean),
+			pos: markPosition(content[i:i + j + 1]),
+		})
+		content = content[i + j + 1:]
+	}
+	sm.content = append(clean, content...)
+	return sm.content, sm
+}
+
+// markPosition returns the position of the mark.
+func markPosition(mark []byte) Position {
+	if len(mark) < 2 {
+		return Position{}
+	}
+	m := string(mark[1:len(mark) - 1])
+	sep := strings.LastIndex(m, ":")
+	if sep == -1 {
+		return Position{}
+	}
+	line, _ := strconv.Atoi(m[sep + 1:])
+	return Position{File: m[:sep], Line: line}
+}
+
+// stripMarks removes all marks of the content.
+func stripMarks(content []byte) []byte {
+	if bytes.IndexByte(content, markStart) == -1 {
+		return content
+	}
+	clean, _ := unmark(content)
+	return clean
+}
+
+// lastMark returns the last mark of the content, or nil.
+func lastMark(content []byte) []byte {
+	i := bytes.LastIndexByte(content, markStart)
+	if i == -1 {
+		return nil
+	}
+	j := bytes.IndexByte(content[i:], markEnd)
+	if j == -1 {
+		return nil
+	}
+	return content[i:i + j + 1]
+}
+
+// withMark returns a copy of the content which starts with the mark, if the
+// content does not start with a mark yet.
+func withMark(mark, content []byte) []byte {
+	if len(mark) == 0 || len(content) > 0 && content[0] == markStart {
+		return content
+	}
+	return append(append(make([]byte, 0, len(mark) + len(content)), mark...), content...)
+}
+
+// replaceMarked is like Regexp.ReplaceAllFunc, the repl func gets the
+// submatches and the mark of the line where the match starts. The mark of
+// the line where the match ends is added after the replacement, so the rest
+// of the line keeps its position.
+func replaceMarked(re *regexp.Regexp, content []byte, repl func(sub [][]byte, mark []byte) []byte) []byte {
+	var done, mark []byte
+	last := 0
+	for _, loc := range re.FindAllSubmatchIndex(content, -1) {
+		if m := lastMark(content[last:loc[0]]); m != nil {
+			mark = m
+		}
+		sub := make([][]byte, len(loc) / 2)
+		for i := range sub {
+			if loc[2 * i] >= 0 {
+				sub[i] = content[loc[2 * i]:loc[2 * i + 1]]
+			}
+		}
+		done = append(done, content[last:loc[0]]...)
+		done = append(done, repl(sub, mark)...)
+		if m := lastMark(content[loc[0]:loc[1]]); m != nil {
+			mark = m
+		}
+		done = append(done, mark...)
+		last = loc[1]
+	}
+	return append(done, content[last:]...)
+}
@@ -0,0 +1,63 @@
+package view_test
+
+import (
+	"testing"
+	"bytes"
+	"path/filepath"
+	"gopkg.in/orivil/view.v0"
+)
+
+func TestSourceMap(t *testing.T) {
+	combiner := view.NewCombiner("./testdata/nested", fileExt)
+	content, err := combiner.Combine("index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := combiner.SourceMap()
+	base := filepath.Join("testdata/nested/layouts/base" + fileExt)
+	shop := filepath.Join("testdata/nested/layouts/shop" + fileExt)
+	index := filepath.Join("testdata/nested/index" + fileExt)
+	lines := []view.Position{
+		{File: base, Line: 1},
+		{File: base, Line: 2},
+		{File: base, Line: 3},
+		{File: shop, Line: 4},
+		{File: index, Line: 4},
+		{File: shop, Line: 6},
+		{File: base, Line: 5},
+		{File: base, Line: 6},
+	}
+	for idx, expect := range lines {
+		if got := sm.LinePosition(idx + 1); got != expect {
+			t.Errorf("line %d: got position: %s, expect position: %s", idx + 1, got, expect)
+		}
+	}
+
+	// "<title>Cart</title>"
+	offsets := map[string]view.Position{
+		"Cart": {File: index, Line: 2},
+		"</title>": {File: base, Line: 2},
+	}
+	for text, expect := range offsets {
+		offset := bytes.Index(content, []byte(text))
+		if got := sm.Position(offset); got != expect {
+			t.Errorf("%s: got position: %s, expect position: %s", text, got, expect)
+		}
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	for _, define := range []bool{false, true} {
+		container := view.NewContainer(true, fileExt)
+		container.SetDefineMode(define)
+		err := container.Display(bytes.NewBuffer(nil), nil, view.NewPage("./testdata/broken", "func"))
+		pe, ok := err.(*view.ParseError)
+		if !ok {
+			t.Fatalf("got error: %v, expect *view.ParseError", err)
+		}
+		expect := view.Position{File: filepath.Join("testdata/broken/func" + fileExt), Line: 4}
+		if pe.Position != expect {
+			t.Error("got position:", pe.Position, "expect position:", expect)
+		}
+	}
+}
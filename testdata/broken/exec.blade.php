@@ -0,0 +1,5 @@
+@extends("layout")
+@section("content")
+    <p>{{.Title}}</p>
+    <p>{{.User.Name}}</p>
+@endsection
\ No newline at end of file
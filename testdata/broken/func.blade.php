@@ -0,0 +1,5 @@
+@extends("layout")
+@section("content")
+    <p>{{.}}</p>
+    <p>{{nofunc .}}</p>
+@endsection
\ No newline at end of file
@@ -0,0 +1,3 @@
+<div>
+    @include("partials/broken")
+</div>
\ No newline at end of file
@@ -0,0 +1,5 @@
+<html>
+<body>
+    @yield("content")
+</body>
+</html>
\ No newline at end of file
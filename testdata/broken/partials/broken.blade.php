@@ -0,0 +1,3 @@
+<p>
+    {{if .}}broken
+</p>
\ No newline at end of file
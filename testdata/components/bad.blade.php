@@ -0,0 +1 @@
+<ul>{{range .}}@include("partials/card", {"title" .Name}){{end}}</ul>
\ No newline at end of file
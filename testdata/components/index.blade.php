@@ -0,0 +1,4 @@
+<ul>
+{{range .Items}}    @include("partials/card", {"title": .Name, "price": (printf "%.2f" .Price)})
+{{end}}</ul>
+@include("partials/badge", .Badge)
\ No newline at end of file
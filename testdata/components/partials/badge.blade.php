@@ -0,0 +1 @@
+<b>{{.}}</b>
\ No newline at end of file
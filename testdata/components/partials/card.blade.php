@@ -0,0 +1 @@
+<li>{{.title}}: {{.price}}</li>
\ No newline at end of file
@@ -0,0 +1 @@
+<a>@include("b")</a>
\ No newline at end of file
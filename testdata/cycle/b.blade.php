@@ -0,0 +1 @@
+<b>@include("a")</b>
\ No newline at end of file
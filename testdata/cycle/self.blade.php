@@ -0,0 +1 @@
+@include("self")
\ No newline at end of file
@@ -0,0 +1,2 @@
+@extends("y")
+@section("content")x@endsection
\ No newline at end of file
@@ -0,0 +1,2 @@
+@extends("x")
+@section("content")y@endsection
\ No newline at end of file
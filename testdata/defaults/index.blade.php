@@ -0,0 +1,2 @@
+@extends("layout")
+@section("sidebar")<nav>index</nav>@endsection
\ No newline at end of file
@@ -0,0 +1,2 @@
+<title>@yield("title", "Default Title")</title>
+<aside>@yield("sidebar")@sectionMissing("sidebar")<nav>default</nav>@endif</aside>
\ No newline at end of file
@@ -0,0 +1,3 @@
+<ul>
+    @include("partials/nav")
+</ul>
\ No newline at end of file
@@ -0,0 +1 @@
+<li>{{.}}</li>
\ No newline at end of file
@@ -0,0 +1,2 @@
+@include("partials/item")
+    @include("partials/item")
\ No newline at end of file
@@ -0,0 +1,3 @@
+<ul>
+{{range $item := .}}    @include("partials/item")
+{{end}}</ul>
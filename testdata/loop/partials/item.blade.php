@@ -0,0 +1 @@
+<li>{{$item}}</li>
\ No newline at end of file
@@ -0,0 +1,6 @@
+<html>
+<body>
+    @include("shared::partials.nav")
+    @yield("content")
+</body>
+</html>
\ No newline at end of file
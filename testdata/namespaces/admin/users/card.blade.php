@@ -0,0 +1 @@
+<p>card</p>
@@ -0,0 +1,4 @@
+@extends("admin::layouts.main")
+@section("content")
+    <p>{{.}}</p>
+@endsection
\ No newline at end of file
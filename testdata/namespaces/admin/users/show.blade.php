@@ -0,0 +1 @@
+@include("users/card")
\ No newline at end of file
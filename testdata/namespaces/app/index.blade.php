@@ -0,0 +1 @@
+@include("item")
\ No newline at end of file
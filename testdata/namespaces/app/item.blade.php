@@ -0,0 +1 @@
+<li>app</li>@include("shared::list")
\ No newline at end of file
@@ -0,0 +1 @@
+<nav>app</nav>
\ No newline at end of file
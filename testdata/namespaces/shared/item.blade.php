@@ -0,0 +1 @@
+<li>shared</li>
\ No newline at end of file
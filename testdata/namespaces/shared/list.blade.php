@@ -0,0 +1 @@
+<ul>@include("item")</ul>
\ No newline at end of file
@@ -0,0 +1 @@
+<nav>shared</nav>
\ No newline at end of file
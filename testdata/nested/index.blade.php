@@ -0,0 +1,5 @@
+@extends("layouts/shop")
+@section("title")Cart@endsection
+@section("main")
+    <cart>{{.}}</cart>
+@endsection
\ No newline at end of file
@@ -0,0 +1,6 @@
+<html>
+<title>@yield("title")</title>
+<body>
+    @yield("content")
+</body>
+</html>
\ No newline at end of file
@@ -0,0 +1,7 @@
+@extends("layouts/base")
+@section("title")Shop@endsection
+@section("content")
+    <shop>
+        @yield("main")
+    </shop>
+@endsection
\ No newline at end of file
@@ -0,0 +1,3 @@
+<html><head><title>A</title></head><body>
+    @include("partials/nav", .)
+</body></html>
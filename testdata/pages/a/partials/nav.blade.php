@@ -0,0 +1 @@
+<nav>NAV-A</nav>
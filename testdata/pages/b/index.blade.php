@@ -0,0 +1,3 @@
+<html><head><title>B</title></head><body>
+    @include("partials/nav", .)
+</body></html>
@@ -0,0 +1 @@
+<nav>NAV-B</nav>
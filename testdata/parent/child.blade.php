@@ -0,0 +1,6 @@
+@extends("index")
+@section("scripts")
+    @parent
+    <script src="/child.js"></script>
+@endsection
+@section("nav")<a>child</a>@endsection
\ No newline at end of file
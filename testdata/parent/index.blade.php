@@ -0,0 +1,5 @@
+@extends("layout")
+@section("scripts")
+    @parent
+    <script src="/page.js"></script>
+@endsection
\ No newline at end of file
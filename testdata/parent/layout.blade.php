@@ -0,0 +1,6 @@
+<head>
+    @section("scripts")
+        <script src="/app.js"></script>
+    @show
+</head>
+<nav>@section("nav")<a>home</a>@show</nav>
\ No newline at end of file
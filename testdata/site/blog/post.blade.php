@@ -0,0 +1,4 @@
+---
+title: Post
+---
+<article>{{.title}}: {{.body}}</article>
@@ -0,0 +1,9 @@
+---
+title: Home
+site: Orivil
+---
+@extends("layouts/base")
+@section("title"){{.title}}@endsection
+@section("content")
+    <h1>{{.title}}</h1>
+@endsection
@@ -0,0 +1,7 @@
+<html>
+<title>@yield("title")</title>
+<body>
+    @include("partials/nav")
+    @yield("content")
+</body>
+</html>
@@ -0,0 +1 @@
+<nav>{{.site}}</nav>
\ No newline at end of file
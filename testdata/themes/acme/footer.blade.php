@@ -0,0 +1 @@
+<footer>acme</footer>
\ No newline at end of file
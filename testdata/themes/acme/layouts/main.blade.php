@@ -0,0 +1,4 @@
+<html class="acme">
+<title>Acme - @yield("title")</title>
+<body>@include("footer")</body>
+</html>
\ No newline at end of file
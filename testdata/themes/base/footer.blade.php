@@ -0,0 +1 @@
+<footer>base</footer>
\ No newline at end of file
@@ -0,0 +1,2 @@
+@extends("layouts/main")
+@section("title"){{.}}@endsection
\ No newline at end of file
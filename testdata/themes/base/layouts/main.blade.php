@@ -0,0 +1,4 @@
+<html>
+<title>@yield("title")</title>
+<body>@include("footer")</body>
+</html>
\ No newline at end of file
@@ -0,0 +1,122 @@
+package view
+
+import (
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// Watch starts watching the view files of the cached templates, every
+// interval it checks the modification times of the files, the templates built
+// from the changed files (the page, its layouts and included files) are
+// removed from the cache and will be rebuilt by the next Display, the other
+// templates stay cached. Call stop to stop watching.
+//
+// Unlike the debug mode, only the changed pages are re-read and re-parsed.
+func (this *Container) Watch(interval time.Duration) (stop func()) {
+	this.rwmu.Lock()
+	this.watching = true
+	this.rwmu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				this.reload()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			this.rwmu.Lock()
+			this.watching = false
+			this.rwmu.Unlock()
+		})
+	}
+}
+
+// reload removes the cached templates whose view files have been changed or
+// removed, returns the number of the removed templates.
+func (this *Container) reload() int {
+	files := make(map[string]time.Time)
+	this.tpls.each(func(tpl *cached) {
+		for file := range tpl.files {
+			files[file] = time.Time{}
+		}
+	})
+	this.rwmu.RLock()
+	for _, set := range this.partials {
+		for file := range set.files {
+			files[file] = time.Time{}
+		}
+	}
+	this.rwmu.RUnlock()
+
+	// stat the files without holding the lock
+	var names []string
+	for file := range files {
+		names = append(names, file)
+	}
+	files = this.modTimes(names)
+
+	this.rwmu.Lock()
+	defer this.rwmu.Unlock()
+	removed := this.tpls.removeIf(func(tpl *cached) bool {
+		return tpl.changed(files)
+	})
+	// the shared partials may come from the changed files, even if the
+	// templates using them have been evicted
+	changed := removed > 0
+	for dir, set := range this.partials {
+		if set.changed(files) {
+			delete(this.partials, dir)
+			changed = true
+		}
+	}
+	if changed {
+		// the templates being built may come from the old files
+		this.generation++
+	}
+	return removed
+}
+
+// addModTimes adds the modification times src into dst, the time taken
+// first is kept. dst may be nil.
+func addModTimes(dst, src map[string]time.Time) {
+	if dst == nil {
+		return
+	}
+	for file, modTime := range src {
+		if _, ok := dst[file]; !ok {
+			dst[file] = modTime
+		}
+	}
+}
+
+// modTimes returns the modification times of the files, the time of a file
+// which can not be stat is zero.
+func (this *Container) modTimes(files []string) map[string]time.Time {
+	times := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		var info fs.FileInfo
+		var err error
+		if this.fsys != nil {
+			info, err = fs.Stat(this.fsys, file)
+		} else {
+			info, err = os.Stat(file)
+		}
+		if err == nil {
+			times[file] = info.ModTime()
+		} else {
+			times[file] = time.Time{}
+		}
+	}
+	return times
+}